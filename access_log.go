@@ -0,0 +1,30 @@
+package flecto_traefik_middleware
+
+import "strconv"
+
+// Decisions reported by the access log
+const (
+	decisionPassthrough  = "passthrough"
+	decisionBypass       = "bypass"
+	decisionCanonical    = "canonical"
+	decisionMaintenance  = "maintenance"
+	decisionStale        = "stale"
+	decisionColdStart    = "cold_start"
+	decisionTimeout      = "timeout"
+	decisionURITooLong   = "uri_too_long"
+	decisionTest         = "test"
+	decisionRedirect     = "redirect"
+	decisionPage         = "page"
+	decisionDefaultPage  = "default_page"
+	decisionCapturedPage = "captured_page"
+)
+
+// logAccess writes the access log line of a request.
+// The status is - when the request was passed to the next handler, which chose it.
+func (m *Middleware) logAccess(host, uri, decision string, status int) {
+	code := "-"
+	if status != 0 {
+		code = strconv.Itoa(status)
+	}
+	m.logf("access host=%s uri=%q decision=%s status=%s", host, uri, decision, code)
+}
@@ -0,0 +1,93 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_AccessLog(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri != "/old" {
+				return nil, ""
+			}
+			return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/robots.txt" {
+				return nil
+			}
+			return &types.Page{Type: types.PageTypeBasic, Path: "/robots.txt", Content: "User-agent: *"}
+		},
+	}
+	newMiddleware := func(accessLog bool) *Middleware {
+		return &Middleware{
+			name:          "test",
+			next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			defaultClient: mock,
+			hostClients:   map[string]client.Client{},
+			bypassPaths:   []string{"/healthz"},
+			accessLog:     accessLog,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		requestURL string
+		wantLine   string
+	}{
+		{
+			name:       "redirect",
+			requestURL: "http://example.com/old",
+			wantLine:   `test: access host=example.com uri="/old" decision=redirect status=301`,
+		},
+		{
+			name:       "page",
+			requestURL: "http://example.com/robots.txt",
+			wantLine:   `test: access host=example.com uri="/robots.txt" decision=page status=200`,
+		},
+		{
+			name:       "passthrough",
+			requestURL: "http://example.com/other?q=1",
+			wantLine:   `test: access host=example.com uri="/other?q=1" decision=passthrough status=-`,
+		},
+		{
+			name:       "bypass",
+			requestURL: "http://example.com/healthz",
+			wantLine:   `test: access host=example.com uri="/healthz" decision=bypass status=-`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := captureLogs(t)
+			newMiddleware(true).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.requestURL, nil))
+
+			assert.Equal(t, tt.wantLine+"\n", logs.String())
+		})
+	}
+
+	t.Run("partial page", func(t *testing.T) {
+		logs := captureLogs(t)
+		for _, rangeHeader := range []string{"bytes=0-4", "bytes=100-200"} {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil)
+			req.Header.Set("Range", rangeHeader)
+			newMiddleware(true).ServeHTTP(httptest.NewRecorder(), req)
+		}
+
+		assert.Equal(t, `test: access host=example.com uri="/robots.txt" decision=page status=206`+"\n"+
+			`test: access host=example.com uri="/robots.txt" decision=page status=416`+"\n", logs.String())
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		logs := captureLogs(t)
+		newMiddleware(false).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+
+		assert.False(t, strings.Contains(logs.String(), "access"))
+	})
+}
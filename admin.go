@@ -0,0 +1,154 @@
+package flecto_traefik_middleware
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// adminTokenHeader carries the shared secret expected by AdminHandler when admin_token is set
+const adminTokenHeader = "X-Flecto-Admin-Token"
+
+// ClientStats describes the reload state of a client.
+type ClientStats struct {
+	Key         string    `json:"key"`
+	Version     int       `json:"version"`
+	LastSuccess time.Time `json:"last_success"`
+	Stale       bool      `json:"stale"`
+	// Breaker is the state of the reload circuit breaker: closed, open or half-open
+	Breaker string `json:"breaker"`
+	// Failures counts the consecutive failed reloads, LastError is the error of the last one
+	Failures  int    `json:"failures"`
+	LastError string `json:"last_error,omitempty"`
+	// Broken is set when the client has failed to load and never loaded any rules, so it serves none
+	Broken bool `json:"broken"`
+}
+
+// sortedStates returns the client states ordered by settings key.
+func (m *Middleware) sortedStates() []*clientState {
+	m.hostsMu.RLock()
+	states := make([]*clientState, 0, len(m.states))
+	for _, state := range m.states {
+		states = append(states, state)
+	}
+	m.hostsMu.RUnlock()
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].key < states[j].key
+	})
+	return states
+}
+
+// ReloadAll reloads the rules of every client, without waiting for their next tick.
+// All clients are reloaded even if some fail, the returned error joins every failure.
+func (m *Middleware) ReloadAll() error {
+	var errs []error
+	for _, state := range m.sortedStates() {
+		if err := m.reload(state); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", state.redactedKey, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StateVersions returns the rules version of every client, by settings key.
+func (m *Middleware) StateVersions() map[string]int {
+	states := m.sortedStates()
+	versions := make(map[string]int, len(states))
+	for _, state := range states {
+		versions[state.redactedKey] = state.client.GetStateVersion()
+	}
+	return versions
+}
+
+// Stats returns the reload state of every client, ordered by settings key.
+func (m *Middleware) Stats() []ClientStats {
+	now := time.Now()
+	states := m.sortedStates()
+	stats := make([]ClientStats, 0, len(states))
+	for _, state := range states {
+		lastError, failures := state.reloadError()
+		stats = append(stats, ClientStats{
+			Key:         state.redactedKey,
+			Version:     state.client.GetStateVersion(),
+			LastSuccess: state.lastSuccessAt(),
+			Stale:       state.isStale(now, m.staleAfter),
+			Breaker:     state.breakerState(now),
+			Failures:    failures,
+			LastError:   lastError,
+			Broken:      state.broken(),
+		})
+	}
+	return stats
+}
+
+// AdminHandler returns a handler exposing POST /reload, GET /stats and GET /health for ops tooling.
+// Mount it with http.StripPrefix when serving it under a prefix.
+func (m *Middleware) AdminHandler() http.Handler {
+	return http.HandlerFunc(m.serveAdmin)
+}
+
+func (m *Middleware) serveAdmin(rw http.ResponseWriter, req *http.Request) {
+	if m.adminToken != "" && subtle.ConstantTimeCompare([]byte(req.Header.Get(adminTokenHeader)), []byte(m.adminToken)) != 1 {
+		writeSyntheticError(rw, m.syntheticErrorFormat, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+		return
+	}
+
+	switch req.URL.Path {
+	case "/reload":
+		if !m.allowAdminMethod(rw, req, http.MethodPost) {
+			return
+		}
+		if err := m.ReloadAll(); err != nil {
+			writeSyntheticError(rw, m.syntheticErrorFormat, http.StatusBadGateway, err.Error())
+			return
+		}
+		writeJSON(rw, http.StatusOK, m.StateVersions())
+	case "/stats":
+		if !m.allowAdminMethod(rw, req, http.MethodGet) {
+			return
+		}
+		writeJSON(rw, http.StatusOK, m.Stats())
+	case "/health":
+		if !m.allowAdminMethod(rw, req, http.MethodGet) {
+			return
+		}
+		m.serveHealth(rw)
+	default:
+		writeSyntheticError(rw, m.syntheticErrorFormat, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+	}
+}
+
+// allowAdminMethod answers 405 and returns false when req does not use method.
+func (m *Middleware) allowAdminMethod(rw http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	rw.Header().Set("Allow", method)
+	writeSyntheticError(rw, m.syntheticErrorFormat, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+	return false
+}
+
+// serveHealth answers 503 with the broken clients when a client has never loaded its rules, 200 otherwise.
+func (m *Middleware) serveHealth(rw http.ResponseWriter) {
+	broken := []ClientStats{}
+	for _, stats := range m.Stats() {
+		if stats.Broken {
+			broken = append(broken, stats)
+		}
+	}
+	status := http.StatusOK
+	if len(broken) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, broken)
+}
+
+func writeJSON(rw http.ResponseWriter, status int, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+	_ = json.NewEncoder(rw).Encode(v)
+}
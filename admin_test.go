@@ -0,0 +1,172 @@
+package flecto_traefik_middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func newAdminMiddleware(clients map[string]*mockClient) *Middleware {
+	m := &Middleware{
+		name:        "test",
+		hostClients: map[string]client.Client{},
+		states:      map[client.Client]*clientState{},
+	}
+	for key, c := range clients {
+		m.states[c] = newClientState(key, c)
+	}
+	return m
+}
+
+func TestMiddleware_ReloadAll(t *testing.T) {
+	captureLogs(t)
+	ok := &mockClient{stateVersion: 2}
+	failing := &mockClient{reloadErr: errors.New("connection refused")}
+	m := newAdminMiddleware(map[string]*mockClient{"a": ok, "b": failing})
+
+	err := m.ReloadAll()
+
+	assert.EqualError(t, err, "b: connection refused")
+	assert.True(t, ok.reloadCalled)
+	assert.True(t, failing.reloadCalled)
+	assert.False(t, m.states[ok].lastSuccessAt().IsZero())
+}
+
+func TestMiddleware_StateVersions(t *testing.T) {
+	m := newAdminMiddleware(map[string]*mockClient{
+		"a": {stateVersion: 2},
+		"b": {stateVersion: 5},
+	})
+
+	assert.Equal(t, map[string]int{"a": 2, "b": 5}, m.StateVersions())
+}
+
+func TestMiddleware_Stats(t *testing.T) {
+	fresh := &mockClient{stateVersion: 2}
+	stale := &mockClient{stateVersion: 1}
+	m := newAdminMiddleware(map[string]*mockClient{"b": fresh, "a": stale})
+	m.staleAfter = time.Minute
+	m.states[fresh].recordReload(nil)
+	m.states[stale].lastSuccess = time.Now().Add(-time.Hour)
+
+	stats := m.Stats()
+
+	assert.Len(t, stats, 2)
+	assert.Equal(t, "a", stats[0].Key)
+	assert.Equal(t, 1, stats[0].Version)
+	assert.True(t, stats[0].Stale)
+	assert.Equal(t, "b", stats[1].Key)
+	assert.Equal(t, 2, stats[1].Version)
+	assert.False(t, stats[1].Stale)
+}
+
+func TestMiddleware_AdminHandler(t *testing.T) {
+	newHandler := func(token string, c *mockClient) http.Handler {
+		m := newAdminMiddleware(map[string]*mockClient{"key": c})
+		m.adminToken = token
+		return m.AdminHandler()
+	}
+
+	t.Run("reload", func(t *testing.T) {
+		c := &mockClient{stateVersion: 3}
+		rec := httptest.NewRecorder()
+		newHandler("", c).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, c.reloadCalled)
+		assert.JSONEq(t, `{"key":3}`, rec.Body.String())
+	})
+
+	t.Run("reload failure", func(t *testing.T) {
+		captureLogs(t)
+		rec := httptest.NewRecorder()
+		newHandler("", &mockClient{reloadErr: errors.New("boom")}).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+
+		assert.Equal(t, http.StatusBadGateway, rec.Code)
+		assert.Contains(t, rec.Body.String(), "key: boom")
+	})
+
+	t.Run("reload requires POST", func(t *testing.T) {
+		c := &mockClient{}
+		rec := httptest.NewRecorder()
+		newHandler("", c).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/reload", nil))
+
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		assert.Equal(t, http.MethodPost, rec.Header().Get("Allow"))
+		assert.False(t, c.reloadCalled)
+	})
+
+	t.Run("stats", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newHandler("", &mockClient{stateVersion: 4}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stats", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		var stats []ClientStats
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+		assert.Equal(t, []ClientStats{{Key: "key", Version: 4, Breaker: breakerClosed}}, stats)
+	})
+
+	t.Run("health", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newHandler("", &mockClient{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `[]`, rec.Body.String())
+	})
+
+	t.Run("health reports clients that never loaded", func(t *testing.T) {
+		m := newAdminMiddleware(map[string]*mockClient{"broken": {}, "loaded": {}})
+		for c, state := range m.states {
+			if state.key == "broken" {
+				for i := 0; i < 3; i++ {
+					state.recordReload(errors.New("unauthorized"))
+				}
+			} else {
+				m.states[c].recordReload(nil)
+			}
+		}
+		rec := httptest.NewRecorder()
+		m.AdminHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		var stats []ClientStats
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &stats))
+		assert.Len(t, stats, 1)
+		assert.Equal(t, "broken", stats[0].Key)
+		assert.Equal(t, 3, stats[0].Failures)
+		assert.Equal(t, "unauthorized", stats[0].LastError)
+		assert.True(t, stats[0].Broken)
+	})
+
+	t.Run("unknown route", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newHandler("", &mockClient{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("token required", func(t *testing.T) {
+		c := &mockClient{}
+		rec := httptest.NewRecorder()
+		newHandler("secret", c).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+		assert.False(t, c.reloadCalled)
+	})
+
+	t.Run("valid token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/stats", nil)
+		req.Header.Set(adminTokenHeader, "secret")
+		rec := httptest.NewRecorder()
+		newHandler("secret", &mockClient{}).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
@@ -0,0 +1,30 @@
+package flecto_traefik_middleware
+
+import "net/http"
+
+// canonicalURL returns the url req is redirected to once every enabled canonicalization is applied,
+// or an empty string when req is already canonical.
+// Computing the whole canonical form at once issues a single redirect instead of one per canonicalization,
+// and comparing it to the request prevents redirect loops.
+func (m *Middleware) canonicalURL(req *http.Request, policy *hostPolicy) string {
+	scheme, host := m.scheme(req), req.Host
+	canonicalScheme, canonicalHost := scheme, host
+
+	// The port of the plain HTTP entrypoint is dropped when upgrading to HTTPS
+	if m.shouldForceHTTPS(req, policy) {
+		canonicalScheme, canonicalHost = "https", hostname(host)
+	}
+
+	if canonicalScheme == scheme && canonicalHost == host {
+		return ""
+	}
+	return canonicalScheme + "://" + canonicalHost + req.URL.RequestURI()
+}
+
+// serveCanonicalRedirect permanently redirects req to its canonical url.
+func (m *Middleware) serveCanonicalRedirect(rw http.ResponseWriter, req *http.Request, target string) {
+	if m.trustForwardedHeaders {
+		addVary(rw.Header(), "X-Forwarded-Proto")
+	}
+	http.Redirect(rw, req, target, http.StatusPermanentRedirect)
+}
@@ -0,0 +1,56 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_CanonicalURL(t *testing.T) {
+	m := &Middleware{forceHTTPS: true}
+
+	tests := []struct {
+		name       string
+		requestURL string
+		want       string
+	}{
+		{name: "http is upgraded", requestURL: "http://example.com/path?a=1", want: "https://example.com/path?a=1"},
+		{name: "http port is dropped", requestURL: "http://example.com:8080/path", want: "https://example.com/path"},
+		{name: "already canonical", requestURL: "https://example.com/path"},
+		{name: "https port is kept", requestURL: "https://example.com:8443/path"},
+		{name: "ipv6 http port is dropped", requestURL: "http://[2001:db8::1]:80/path", want: "https://[2001:db8::1]/path"},
+		{name: "ipv6 without port", requestURL: "http://[2001:db8::1]/path", want: "https://[2001:db8::1]/path"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, m.canonicalURL(httptest.NewRequest(http.MethodGet, tt.requestURL, nil), nil))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_CanonicalSingleRedirect(t *testing.T) {
+	nextCalled := false
+	m := &Middleware{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nextCalled = true
+		}),
+		defaultClient: &mockClient{},
+		hostClients:   map[string]client.Client{},
+		forceHTTPS:    true,
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com:8080/path?a=1", nil))
+	assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+	location := rec.Header().Get("Location")
+	assert.Equal(t, "https://example.com/path?a=1", location)
+
+	// Following the redirect reaches the next handler without another hop
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, location, nil))
+	assert.True(t, nextCalled)
+	assert.Empty(t, rec.Header().Get("Location"))
+}
@@ -0,0 +1,96 @@
+package flecto_traefik_middleware
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+)
+
+// capturedHeaders describe the body of a captured response, they are removed before serving the page
+var capturedHeaders = []string{"Content-Encoding", "Content-Length", "Content-Range", "Etag", "Last-Modified"}
+
+// captureWriter holds back the response of the next handler when its status is one of statuses,
+// so a page can be served in its place. Other responses are written through without buffering.
+type captureWriter struct {
+	http.ResponseWriter
+	statuses []int
+	// status is the captured status, 0 while the response is written through
+	status      int
+	wroteHeader bool
+}
+
+func (w *captureWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	// Informational responses precede the final one and are always forwarded
+	if code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	for _, status := range w.statuses {
+		if code == status {
+			w.status = code
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.status != 0 {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *captureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && w.status == 0 {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// serveNextCaptured passes req to the next handler, serving the capture page in place of its response
+// when the status is one of capture_page_statuses. The page is looked up first, nothing is captured without it.
+func (m *Middleware) serveNextCaptured(rw http.ResponseWriter, req *http.Request, c client.Client, policy *hostPolicy) (string, int) {
+	if len(m.capturePageStatuses) == 0 || m.pagesDisabled {
+		m.serveNext(rw, req)
+		return decisionPassthrough, 0
+	}
+	page := matchPage(c, policy, req.Host, m.capturePagePath)
+	if page == nil {
+		m.serveNext(rw, req)
+		return decisionPassthrough, 0
+	}
+	cw := &captureWriter{ResponseWriter: rw, statuses: m.capturePageStatuses}
+	m.serveNext(cw, req)
+	if cw.status == 0 {
+		return decisionPassthrough, 0
+	}
+	m.serveCapturedPage(rw, req, page, cw.status)
+	return decisionCapturedPage, cw.status
+}
+
+// serveCapturedPage serves page with the status of the captured response.
+func (m *Middleware) serveCapturedPage(rw http.ResponseWriter, req *http.Request, page *types.Page, status int) {
+	for _, name := range capturedHeaders {
+		rw.Header().Del(name)
+	}
+	rw = headWriter(rw, req)
+	rw.Header().Set("Content-Type", pageContentType(page, m.defaultPageContentType))
+	rw.Header().Set("Content-Length", strconv.Itoa(len(page.Content)))
+	rw.WriteHeader(status)
+	_, _ = io.WriteString(rw, page.Content)
+}
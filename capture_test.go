@@ -0,0 +1,117 @@
+package flecto_traefik_middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_CapturePage(t *testing.T) {
+	const removed = "This content has been removed"
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri == "/errors/removed" {
+				return &types.Page{Type: types.PageTypeBasic, Path: "/errors/removed", Content: removed, ContentType: types.PageContentTypeTextPlain}
+			}
+			return nil
+		},
+	}
+	// next answers with the status given in the path, e.g. /status/404
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Etag", `"backend"`)
+		switch r.URL.Path {
+		case "/status/404":
+			w.WriteHeader(http.StatusNotFound)
+		case "/status/410":
+			w.WriteHeader(http.StatusGone)
+		case "/status/500":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_, _ = io.WriteString(w, `{"error":"backend"}`)
+	})
+	newMiddleware := func(capturePagePath string) *Middleware {
+		return &Middleware{
+			next:                next,
+			defaultClient:       mock,
+			hostClients:         map[string]client.Client{},
+			capturePageStatuses: []int{http.StatusNotFound, http.StatusGone},
+			capturePagePath:     capturePagePath,
+		}
+	}
+
+	for _, status := range []int{http.StatusNotFound, http.StatusGone} {
+		t.Run(http.StatusText(status)+" is captured", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			newMiddleware("/errors/removed").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/status/"+strconv.Itoa(status), nil))
+
+			assert.Equal(t, status, rec.Code)
+			assert.Equal(t, removed, rec.Body.String())
+			assert.Equal(t, "text/plain", rec.Header().Get("Content-Type"))
+			assert.Equal(t, strconv.Itoa(len(removed)), rec.Header().Get("Content-Length"))
+			assert.Empty(t, rec.Header().Get("Etag"))
+		})
+	}
+
+	t.Run("non captured status is streamed through", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware("/errors/removed").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/status/500", nil))
+
+		assert.Equal(t, http.StatusInternalServerError, rec.Code)
+		assert.Equal(t, `{"error":"backend"}`, rec.Body.String())
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `"backend"`, rec.Header().Get("Etag"))
+	})
+
+	t.Run("implicit 200 is streamed through", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware("/errors/removed").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/status/200", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `{"error":"backend"}`, rec.Body.String())
+	})
+
+	t.Run("missing page keeps the response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware("/errors/unknown").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/status/404", nil))
+
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+		assert.Equal(t, `{"error":"backend"}`, rec.Body.String())
+	})
+
+	t.Run("head gets no body", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware("/errors/removed").ServeHTTP(rec, httptest.NewRequest(http.MethodHead, "http://example.com/status/410", nil))
+
+		assert.Equal(t, http.StatusGone, rec.Code)
+		assert.Equal(t, strconv.Itoa(len(removed)), rec.Header().Get("Content-Length"))
+		assert.Zero(t, rec.Body.Len())
+	})
+}
+
+func TestCaptureWriter_Flush(t *testing.T) {
+	t.Run("written through", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := &captureWriter{ResponseWriter: rec, statuses: []int{http.StatusNotFound}}
+		w.WriteHeader(http.StatusOK)
+		w.Flush()
+
+		assert.True(t, rec.Flushed)
+	})
+
+	t.Run("captured", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := &captureWriter{ResponseWriter: rec, statuses: []int{http.StatusNotFound}}
+		w.WriteHeader(http.StatusNotFound)
+		w.Flush()
+
+		assert.False(t, rec.Flushed)
+		assert.Equal(t, http.StatusNotFound, w.status)
+	})
+}
@@ -0,0 +1,115 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/flectolab/go-client"
+)
+
+// pooledClient is a client shared by the middlewares enabling shared_clients.
+type pooledClient struct {
+	state  *clientState
+	cancel context.CancelFunc
+	// users counts the uses of the client by each middleware instance, its ticker is stopped when none is left
+	users map[*Middleware]int
+}
+
+// Process-wide pool of shared clients, keyed by poolKey
+var (
+	clientPool   = make(map[string]*pooledClient)
+	clientPoolMu sync.Mutex
+)
+
+// poolKey identifies a shared client by the whole effective settings and the circuit breaker of its state,
+// so middlewares with other credentials, headers or intervals never share a client.
+func (m *Middleware) poolKey(settings ClientSettings) string {
+	// Maps are encoded in key order, the encoding is stable
+	encoded, _ := json.Marshal(settings)
+	return fmt.Sprintf("%s|%d|%s", encoded, m.reloadFailureThreshold, m.reloadCooldown)
+}
+
+// pooledClient returns the shared client of settings, creating it on first use.
+// The client is released when the middleware is cancelled, and stopped once no middleware uses it.
+// Its reloads are logged and reported by every middleware using it.
+func (m *Middleware) pooledClient(settings ClientSettings) (client.Client, error) {
+	key := m.poolKey(settings)
+
+	clientPoolMu.Lock()
+	p, exists := clientPool[key]
+	if exists {
+		p.users[m]++
+	}
+	clientPoolMu.Unlock()
+
+	if !exists {
+		// Created outside of the lock, the initial load may wait on the manager and the init retries
+		state, interval, err := m.newState(settings)
+		if err != nil {
+			return nil, err
+		}
+		clientPoolMu.Lock()
+		if p, exists = clientPool[key]; exists {
+			// Another middleware created the client meanwhile, this one is dropped before its ticker starts
+			p.users[m]++
+		} else {
+			ctx, cancel := context.WithCancel(context.Background())
+			p = &pooledClient{state: state, cancel: cancel, users: map[*Middleware]int{m: 1}}
+			clientPool[key] = p
+			// The ticker outlives the creating middleware, it is only stopped by releaseClient
+			startTicker(ctx, m.clock, interval, p.reload)
+		}
+		clientPoolMu.Unlock()
+	}
+
+	m.hostsMu.Lock()
+	m.states[p.state.client] = p.state
+	m.hostsMu.Unlock()
+
+	go func() {
+		<-m.cancelCtx.Done()
+		releaseClient(key, m)
+	}()
+	return p.state.client, nil
+}
+
+// reload is the ticker work of a shared client, skipped while its circuit breaker is open.
+// The outcome is reported to the middlewares using the client when it completes.
+func (p *pooledClient) reload() {
+	if p.state.breakerState(time.Now()) == breakerOpen {
+		return
+	}
+	err := p.state.client.Reload()
+	opened := p.state.recordReload(err)
+
+	clientPoolMu.Lock()
+	users := make([]*Middleware, 0, len(p.users))
+	for m := range p.users {
+		users = append(users, m)
+	}
+	clientPoolMu.Unlock()
+	for _, m := range users {
+		m.reportReload(p.state, err, opened)
+	}
+}
+
+// releaseClient drops a use of a shared client by m, stopping its ticker when it was the last one.
+func releaseClient(key string, m *Middleware) {
+	clientPoolMu.Lock()
+	defer clientPoolMu.Unlock()
+	p, exists := clientPool[key]
+	if !exists {
+		return
+	}
+	p.users[m]--
+	if p.users[m] <= 0 {
+		delete(p.users, m)
+	}
+	if len(p.users) == 0 {
+		p.cancel()
+		delete(clientPool, key)
+	}
+}
@@ -0,0 +1,262 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingClient counts its reloads, which run on the ticker goroutine
+type countingClient struct {
+	mockClient
+	reloads atomic.Int32
+}
+
+func (c *countingClient) Reload() error {
+	c.reloads.Add(1)
+	return nil
+}
+
+// poolRefs returns the uses of the shared clients with the settings key key.
+func poolRefs(key string) int {
+	clientPoolMu.Lock()
+	defer clientPoolMu.Unlock()
+	refs := 0
+	for _, p := range clientPool {
+		if p.state.key == key {
+			for _, uses := range p.users {
+				refs += uses
+			}
+		}
+	}
+	return refs
+}
+
+func TestNew_SharedClients(t *testing.T) {
+	originalFactory, originalClock := clientFactory, systemClock
+	defer func() { clientFactory, systemClock = originalFactory, originalClock }()
+
+	fake := &fakeClock{}
+	systemClock = fake
+	var created []*countingClient
+	clientFactory = func(cfg *client.Config) client.Client {
+		c := &countingClient{}
+		created = append(created, c)
+		return c
+	}
+
+	config := &Config{
+		ClientSettings: ClientSettings{
+			ManagerUrl:    "http://localhost:8080",
+			NamespaceCode: "ns",
+			ProjectCode:   "shared-proj",
+			TokenJWT:      "token",
+			IntervalCheck: "20ms",
+		},
+		SharedClients: true,
+	}
+	key := settingsKey(config.ClientSettings)
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	handler1, err := New(ctx1, http.NotFoundHandler(), config, "test-shared-1")
+	assert.NoError(t, err)
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	handler2, err := New(ctx2, http.NotFoundHandler(), config, "test-shared-2")
+	assert.NoError(t, err)
+
+	t.Run("identical settings share one client", func(t *testing.T) {
+		assert.Len(t, created, 1)
+		assert.Same(t, handler1.(*Middleware).defaultClient, handler2.(*Middleware).defaultClient)
+		assert.Equal(t, 2, poolRefs(key))
+	})
+
+	t.Run("one ticker reloads the shared client", func(t *testing.T) {
+		fake.mu.Lock()
+		tickers := len(fake.tickers)
+		fake.mu.Unlock()
+		assert.Equal(t, 1, tickers)
+
+		ticker := fake.ticker(0)
+		assert.Equal(t, 20*time.Millisecond, ticker.interval)
+		ticker.tick(t)
+		ticker.tick(t)
+		assert.Eventually(t, func() bool { return created[0].reloads.Load() == 2 }, time.Second, time.Millisecond)
+	})
+
+	t.Run("client is stopped with its last middleware", func(t *testing.T) {
+		ticker := fake.ticker(0)
+		cancel1()
+		assert.Eventually(t, func() bool { return poolRefs(key) == 1 }, time.Second, 5*time.Millisecond)
+		ticker.tick(t)
+
+		cancel2()
+		assert.Eventually(t, func() bool { return poolRefs(key) == 0 }, time.Second, 5*time.Millisecond)
+		select {
+		case <-ticker.stopped:
+		case <-time.After(time.Second):
+			t.Fatal("ticker not stopped once the last middleware is canceled")
+		}
+	})
+}
+
+func TestNew_SharedClientsDisabled(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+
+	created := 0
+	clientFactory = func(cfg *client.Config) client.Client {
+		created++
+		return &mockClient{}
+	}
+
+	config := &Config{
+		ClientSettings: ClientSettings{
+			ManagerUrl:    "http://localhost:8080",
+			NamespaceCode: "ns",
+			ProjectCode:   "own-proj",
+			TokenJWT:      "token",
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	_, err := New(ctx, http.NotFoundHandler(), config, "test-own-1")
+	assert.NoError(t, err)
+	_, err = New(ctx, http.NotFoundHandler(), config, "test-own-2")
+	assert.NoError(t, err)
+
+	assert.Equal(t, 2, created)
+	assert.Zero(t, poolRefs(settingsKey(config.ClientSettings)))
+}
+
+func TestNew_SharedClientsSettings(t *testing.T) {
+	originalFactory, originalClock := clientFactory, systemClock
+	defer func() { clientFactory, systemClock = originalFactory, originalClock }()
+
+	fake := &fakeClock{}
+	systemClock = fake
+	var created []client.Config
+	var createdMu sync.Mutex
+	// Init of the slow project blocks until released, to check it does not block the pool
+	release := make(chan struct{})
+	clientFactory = func(cfg *client.Config) client.Client {
+		createdMu.Lock()
+		created = append(created, *cfg)
+		createdMu.Unlock()
+		if cfg.ProjectCode == "slow-proj" {
+			return &blockingInitClient{release: release}
+		}
+		return &mockClient{stateVersion: 1}
+	}
+	settings := ClientSettings{
+		ManagerUrl:    "http://localhost:8080",
+		NamespaceCode: "ns",
+		ProjectCode:   "settings-proj",
+		TokenJWT:      "token",
+	}
+	var instances atomic.Int32
+	newMiddleware := func(t *testing.T, settings ClientSettings, onReload func(key string, version int, err error)) (*Middleware, context.CancelFunc) {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		// Each instance has its own name, New cancels the previous instance of a name
+		name := fmt.Sprintf("test-shared-settings-%d", instances.Add(1))
+		handler, err := New(ctx, http.NotFoundHandler(), &Config{ClientSettings: settings, SharedClients: true, OnReload: onReload}, name)
+		assert.NoError(t, err)
+		return handler.(*Middleware), cancel
+	}
+
+	t.Run("other credentials or intervals get their own client", func(t *testing.T) {
+		created = nil
+		m1, _ := newMiddleware(t, settings, nil)
+		other := settings
+		other.TokenJWT = "other-token"
+		m2, _ := newMiddleware(t, other, nil)
+		other = settings
+		other.IntervalCheck = "1m"
+		m3, _ := newMiddleware(t, other, nil)
+		m4, _ := newMiddleware(t, settings, nil)
+
+		assert.Len(t, created, 3)
+		assert.NotSame(t, m1.defaultClient, m2.defaultClient)
+		assert.NotSame(t, m1.defaultClient, m3.defaultClient)
+		assert.Same(t, m1.defaultClient, m4.defaultClient)
+	})
+
+	t.Run("reloads are reported to every middleware until it is cancelled", func(t *testing.T) {
+		shared := settings
+		shared.ProjectCode = "reported-proj"
+		reloads1, reloads2 := make(chan string, 1), make(chan string, 1)
+		fake.mu.Lock()
+		first := len(fake.tickers)
+		fake.mu.Unlock()
+		_, cancel1 := newMiddleware(t, shared, func(key string, version int, err error) { reloads1 <- key })
+		_, _ = newMiddleware(t, shared, func(key string, version int, err error) { reloads2 <- key })
+		// The second middleware starts no ticker of its own
+		fake.mu.Lock()
+		assert.Len(t, fake.tickers, first+1)
+		fake.mu.Unlock()
+		ticker := fake.ticker(first)
+
+		ticker.tick(t)
+		assert.Equal(t, "http://localhost:8080|ns|reported-proj", <-reloads1)
+		assert.Equal(t, "http://localhost:8080|ns|reported-proj", <-reloads2)
+
+		cancel1()
+		assert.Eventually(t, func() bool { return poolRefs("http://localhost:8080|ns|reported-proj") == 1 }, time.Second, 5*time.Millisecond)
+		ticker.tick(t)
+		assert.Equal(t, "http://localhost:8080|ns|reported-proj", <-reloads2)
+		assert.Empty(t, reloads1)
+	})
+
+	t.Run("a slow initial load does not block other middlewares", func(t *testing.T) {
+		slow := settings
+		slow.ProjectCode = "slow-proj"
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			newMiddleware(t, slow, nil)
+		}()
+		assert.Eventually(t, func() bool {
+			createdMu.Lock()
+			defer createdMu.Unlock()
+			return len(created) > 0 && created[len(created)-1].ProjectCode == "slow-proj"
+		}, time.Second, time.Millisecond)
+
+		defer func() {
+			close(release)
+			<-done
+		}()
+		fast := settings
+		fast.ProjectCode = "fast-proj"
+		fastDone := make(chan struct{})
+		go func() {
+			defer close(fastDone)
+			newMiddleware(t, fast, nil)
+		}()
+		select {
+		case <-fastDone:
+		case <-time.After(time.Second):
+			t.Fatal("New blocked by the initial load of another shared client")
+		}
+	})
+}
+
+// blockingInitClient blocks its Init until release is closed
+type blockingInitClient struct {
+	mockClient
+	release chan struct{}
+}
+
+func (c *blockingInitClient) Init() error {
+	<-c.release
+	return nil
+}
@@ -0,0 +1,135 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"hash/fnv"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flectolab/go-client"
+)
+
+// clientState tracks the reload outcome of a client shared by one or more hosts.
+type clientState struct {
+	key    string
+	client client.Client
+	// id identifies the client in debug headers without exposing its settings
+	id string
+	// redactedKey is the key with the manager url passwords redacted, exposed by the unauthenticated handlers
+	redactedKey string
+	// stop stops the reload ticker of the client
+	stop context.CancelFunc
+
+	// breakerThreshold consecutive failures pause the ticker reloads for breakerCooldown, 0 disables the breaker
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	mu          sync.Mutex
+	lastSuccess time.Time
+	failures    int
+	openUntil   time.Time
+	// lastError is the error of the last Init or Reload, empty once a reload succeeds
+	lastError string
+	// reloads and reloadFailures count every Init or Reload call and those which failed
+	reloads        int
+	reloadFailures int
+}
+
+// States of the reload circuit breaker
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+func newClientState(key string, c client.Client) *clientState {
+	return &clientState{key: key, client: c, id: clientID(key), redactedKey: key}
+}
+
+// clientID hashes a settings key into a short identifier.
+func clientID(key string) string {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// recordReload stores the outcome of an Init or Reload call.
+// It reports whether the failure opened the circuit breaker.
+func (s *clientState) recordReload(err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloads++
+	if err != nil {
+		s.reloadFailures++
+		s.failures++
+		s.lastError = strings.TrimSpace(err.Error())
+		if s.breakerThreshold > 0 && s.failures >= s.breakerThreshold {
+			s.openUntil = time.Now().Add(s.breakerCooldown)
+			return true
+		}
+		return false
+	}
+	s.failures = 0
+	s.lastError = ""
+	s.openUntil = time.Time{}
+	s.lastSuccess = time.Now()
+	return false
+}
+
+// breakerState returns the state of the circuit breaker at now.
+// Once the cooldown is over the breaker is half-open: the next reload decides whether it closes or opens again.
+func (s *clientState) breakerState(now time.Time) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.openUntil.IsZero():
+		return breakerClosed
+	case now.Before(s.openUntil):
+		return breakerOpen
+	default:
+		return breakerHalfOpen
+	}
+}
+
+// reloadError returns the error of the last reload and the number of consecutive failures, empty when it succeeded.
+func (s *clientState) reloadError() (string, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastError, s.failures
+}
+
+// reloadCounts returns the number of Init or Reload calls and of those which failed.
+func (s *clientState) reloadCounts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reloads, s.reloadFailures
+}
+
+// broken reports whether the client has failed to load and never loaded any rules.
+func (s *clientState) broken() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSuccess.IsZero() && s.lastError != ""
+}
+
+// lastSuccessAt returns the time of the last successful reload, zero when there was none.
+func (s *clientState) lastSuccessAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastSuccess
+}
+
+// isStale reports whether the last successful reload is older than after.
+// A client that never loaded successfully is not considered stale, as it has no rules to serve.
+func (s *clientState) isStale(now time.Time, after time.Duration) bool {
+	if after <= 0 {
+		return false
+	}
+	lastSuccess := s.lastSuccessAt()
+	if lastSuccess.IsZero() {
+		return false
+	}
+	return now.Sub(lastSuccess) > after
+}
@@ -0,0 +1,222 @@
+package flecto_traefik_middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientState_RecordReload(t *testing.T) {
+	t.Run("success updates last success", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.recordReload(nil)
+		assert.False(t, state.lastSuccess.IsZero())
+	})
+
+	t.Run("failure keeps last success", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.recordReload(errors.New("connection refused"))
+		assert.True(t, state.lastSuccess.IsZero())
+	})
+
+	t.Run("repeated failures are tracked until a success", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.recordReload(errors.New("connection refused"))
+		state.recordReload(errors.New("unauthorized\n"))
+
+		lastError, failures := state.reloadError()
+		assert.Equal(t, "unauthorized", lastError)
+		assert.Equal(t, 2, failures)
+		assert.True(t, state.broken())
+
+		state.recordReload(nil)
+		lastError, failures = state.reloadError()
+		assert.Empty(t, lastError)
+		assert.Zero(t, failures)
+		assert.False(t, state.broken())
+	})
+
+	t.Run("failure after a success is not broken", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.recordReload(nil)
+		state.recordReload(errors.New("connection refused"))
+		assert.False(t, state.broken())
+	})
+}
+
+func TestClientState_IsStale(t *testing.T) {
+	now := time.Now()
+
+	t.Run("disabled when duration is zero", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.lastSuccess = now.Add(-time.Hour)
+		assert.False(t, state.isStale(now, 0))
+	})
+
+	t.Run("never loaded is not stale", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		assert.False(t, state.isStale(now, time.Minute))
+	})
+
+	t.Run("recent success is not stale", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.lastSuccess = now.Add(-30 * time.Second)
+		assert.False(t, state.isStale(now, time.Minute))
+	})
+
+	t.Run("old success is stale", func(t *testing.T) {
+		state := newClientState("key", &mockClient{})
+		state.lastSuccess = now.Add(-2 * time.Minute)
+		assert.True(t, state.isStale(now, time.Minute))
+	})
+}
+
+func TestClientState_Breaker(t *testing.T) {
+	state := newClientState("key", &mockClient{})
+	state.breakerThreshold = 2
+	state.breakerCooldown = time.Minute
+	failure := errors.New("connection refused")
+
+	assert.False(t, state.recordReload(failure))
+	assert.Equal(t, breakerClosed, state.breakerState(time.Now()))
+
+	assert.True(t, state.recordReload(failure))
+	assert.Equal(t, breakerOpen, state.breakerState(time.Now()))
+
+	afterCooldown := time.Now().Add(2 * time.Minute)
+	assert.Equal(t, breakerHalfOpen, state.breakerState(afterCooldown))
+
+	// A failure while half-open opens the breaker again
+	assert.True(t, state.recordReload(failure))
+	assert.Equal(t, breakerOpen, state.breakerState(time.Now()))
+	assert.Equal(t, breakerHalfOpen, state.breakerState(afterCooldown.Add(time.Minute)))
+
+	assert.False(t, state.recordReload(nil))
+	assert.Equal(t, breakerClosed, state.breakerState(time.Now()))
+}
+
+func TestReloadClient_Breaker(t *testing.T) {
+	logs := captureLogs(t)
+	mock := &mockClient{reloadErr: errors.New("connection refused")}
+	state := newClientState("http://localhost|ns|proj", mock)
+	state.breakerThreshold = 2
+	state.breakerCooldown = time.Minute
+	m := &Middleware{name: "test-middleware"}
+
+	m.reloadClient(state)()
+	m.reloadClient(state)()
+	assert.Contains(t, logs.String(), "Pausing reloads of http://localhost|ns|proj for 1m0s after 2 consecutive failures")
+
+	// Open: the ticker skips the reload
+	mock.reloadCalled = false
+	m.reloadClient(state)()
+	assert.False(t, mock.reloadCalled)
+
+	// Half-open: the next tick tries again and closes the breaker on success
+	state.openUntil = time.Now().Add(-time.Second)
+	mock.reloadErr = nil
+	m.reloadClient(state)()
+	assert.True(t, mock.reloadCalled)
+	assert.Equal(t, breakerClosed, state.breakerState(time.Now()))
+
+	// ReloadAll is not subject to the breaker
+	mock.reloadErr = errors.New("connection refused")
+	m.reloadClient(state)()
+	m.reloadClient(state)()
+	mock.reloadCalled = false
+	m.states = map[client.Client]*clientState{mock: state}
+	_ = m.ReloadAll()
+	assert.True(t, mock.reloadCalled)
+}
+
+func TestReloadClient_RecordsLastSuccess(t *testing.T) {
+	mock := &mockClient{}
+	state := newClientState("http://localhost|ns|proj", mock)
+	m := &Middleware{name: "test-middleware"}
+
+	m.reloadClient(state)()
+	assert.False(t, state.lastSuccess.IsZero())
+
+	lastSuccess := state.lastSuccess
+	mock.reloadErr = errors.New("connection refused")
+	m.reloadClient(state)()
+	assert.Equal(t, lastSuccess, state.lastSuccess)
+}
+
+func TestMiddleware_ServeHTTP_Stale(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: "/old",
+				Target: "/new",
+				Status: types.RedirectStatusFound,
+			}, "/new"
+		},
+	}
+
+	tests := []struct {
+		name           string
+		lastSuccess    time.Duration
+		staleStatus    int
+		wantStatusCode int
+		wantNextCalled bool
+	}{
+		{
+			name:           "fresh rules are applied",
+			lastSuccess:    30 * time.Second,
+			wantStatusCode: http.StatusFound,
+			wantNextCalled: false,
+		},
+		{
+			name:           "stale rules pass through",
+			lastSuccess:    2 * time.Minute,
+			wantStatusCode: http.StatusOK,
+			wantNextCalled: true,
+		},
+		{
+			name:           "stale rules serve configured status",
+			lastSuccess:    2 * time.Minute,
+			staleStatus:    http.StatusServiceUnavailable,
+			wantStatusCode: http.StatusServiceUnavailable,
+			wantNextCalled: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled := false
+			next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			})
+
+			state := newClientState("key", mock)
+			state.lastSuccess = time.Now().Add(-tt.lastSuccess)
+
+			m := &Middleware{
+				name:          "test",
+				next:          next,
+				defaultClient: mock,
+				hostClients:   make(map[string]client.Client),
+				states:        map[client.Client]*clientState{mock: state},
+				staleAfter:    time.Minute,
+				staleStatus:   tt.staleStatus,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)
+			rec := httptest.NewRecorder()
+
+			m.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			assert.Equal(t, tt.wantNextCalled, nextCalled)
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package flecto_traefik_middleware
+
+import (
+	"time"
+)
+
+// clock creates the tickers reloading the clients, a fake clock lets tests drive the ticks.
+type clock interface {
+	NewTicker(d time.Duration) ticker
+}
+
+// ticker is the part of time.Ticker used by startTicker.
+type ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the clock of the middlewares created by New, overridden in tests
+var systemClock clock = realClock{}
+
+// realClock creates the tickers of the time package.
+type realClock struct{}
+
+func (realClock) NewTicker(d time.Duration) ticker {
+	return realTicker{ticker: time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
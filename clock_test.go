@@ -0,0 +1,109 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock creates tickers which only tick when told to.
+type fakeClock struct {
+	mu      sync.Mutex
+	tickers []*fakeTicker
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) ticker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTicker{interval: d, c: make(chan time.Time), stopped: make(chan struct{})}
+	c.tickers = append(c.tickers, t)
+	return t
+}
+
+// ticker returns the i-th ticker created by the clock.
+func (c *fakeClock) ticker(i int) *fakeTicker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tickers[i]
+}
+
+type fakeTicker struct {
+	interval time.Duration
+	c        chan time.Time
+	stopOnce sync.Once
+	stopped  chan struct{}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.stopOnce.Do(func() { close(t.stopped) })
+}
+
+// tick blocks until the goroutine of the ticker received the tick, or fails when the ticker is stopped.
+func (t *fakeTicker) tick(tb testing.TB) {
+	tb.Helper()
+	select {
+	case t.c <- time.Now():
+	case <-t.stopped:
+		tb.Fatal("tick on a stopped ticker")
+	}
+}
+
+func TestRealClock(t *testing.T) {
+	ticker := realClock{}.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	select {
+	case <-ticker.C():
+	case <-time.After(time.Second):
+		t.Fatal("real ticker did not tick")
+	}
+}
+
+func TestMiddleware_ReloadTicker_FakeClock(t *testing.T) {
+	originalFactory, originalClock := clientFactory, systemClock
+	defer func() { clientFactory, systemClock = originalFactory, originalClock }()
+
+	fake := &fakeClock{}
+	systemClock = fake
+	clientFactory = func(cfg *client.Config) client.Client {
+		return &mockClient{stateVersion: 1}
+	}
+	reloads := make(chan string, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	_, err := New(ctx, http.NotFoundHandler(), &Config{
+		ClientSettings: ClientSettings{
+			ManagerUrl:    "http://localhost:8080",
+			NamespaceCode: "ns",
+			ProjectCode:   "proj",
+			TokenJWT:      "token",
+			IntervalCheck: "10m",
+		},
+		OnReload: func(key string, version int, err error) {
+			reloads <- key
+		},
+	}, "test-fake-clock")
+	assert.NoError(t, err)
+
+	ticker := fake.ticker(0)
+	assert.Equal(t, 10*time.Minute, ticker.interval)
+	for i := 0; i < 3; i++ {
+		ticker.tick(t)
+		assert.Equal(t, "http://localhost:8080|ns|proj", <-reloads)
+	}
+
+	cancel()
+	select {
+	case <-ticker.stopped:
+	case <-time.After(time.Second):
+		t.Fatal("ticker not stopped once the middleware is canceled")
+	}
+}
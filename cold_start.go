@@ -0,0 +1,18 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// defaultColdStartRetryAfter is sent in the Retry-After header when cold_start_retry_after is not set
+const defaultColdStartRetryAfter = 5 * time.Second
+
+// serveColdStart answers with the cold start page while the client has not loaded any rules yet.
+func (m *Middleware) serveColdStart(rw http.ResponseWriter) {
+	rw.Header().Set("Retry-After", m.coldStartRetryAfter)
+	rw.Header().Set("Cache-Control", "no-store")
+	rw.Header().Set("Content-Type", http.DetectContentType([]byte(m.coldStartPage)))
+	rw.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = rw.Write([]byte(m.coldStartPage))
+}
@@ -0,0 +1,119 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_ColdStart(t *testing.T) {
+	const coldStartPage = "<html><body>Starting up, please retry shortly</body></html>"
+
+	newMiddleware := func(stateVersion int, coldStartPage string) (*Middleware, *bool) {
+		nextCalled := false
+		return &Middleware{
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			}),
+			defaultClient: &mockClient{
+				stateVersion: stateVersion,
+				redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+					if uri == "/old" {
+						return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+					}
+					return nil, ""
+				},
+			},
+			hostClients:         map[string]client.Client{},
+			coldStartPage:       coldStartPage,
+			coldStartRetryAfter: "5",
+		}, &nextCalled
+	}
+
+	t.Run("version 0 serves the cold start page", func(t *testing.T) {
+		m, nextCalled := newMiddleware(0, coldStartPage)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+
+		assert.False(t, *nextCalled)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "5", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+		assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, coldStartPage, rec.Body.String())
+	})
+
+	t.Run("loaded rules are served normally", func(t *testing.T) {
+		m, nextCalled := newMiddleware(1, coldStartPage)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+
+		assert.False(t, *nextCalled)
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "/new", rec.Header().Get("Location"))
+
+		rec = httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/other", nil))
+		assert.True(t, *nextCalled)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("version 0 passes through without cold start page", func(t *testing.T) {
+		m, nextCalled := newMiddleware(0, "")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/other", nil))
+
+		assert.True(t, *nextCalled)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("bypass paths are passed through", func(t *testing.T) {
+		m, nextCalled := newMiddleware(0, coldStartPage)
+		m.bypassPaths = []string{"/healthz"}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/healthz", nil))
+
+		assert.True(t, *nextCalled)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+}
+
+func TestNew_ColdStartRetryAfter(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+	clientFactory = func(cfg *client.Config) client.Client {
+		return &mockClient{}
+	}
+
+	config := CreateConfig()
+	config.ManagerUrl = "http://localhost:8080"
+	config.NamespaceCode = "ns"
+	config.ProjectCode = "proj"
+	config.TokenJWT = "token"
+	config.ColdStartPage = "Starting up"
+
+	t.Run("defaults to 5 seconds", func(t *testing.T) {
+		handler, err := New(context.Background(), http.NotFoundHandler(), config, "test-cold-start")
+		assert.NoError(t, err)
+		assert.Equal(t, "5", handler.(*Middleware).coldStartRetryAfter)
+	})
+
+	t.Run("configured in seconds", func(t *testing.T) {
+		config.ColdStartRetryAfter = "1m"
+		handler, err := New(context.Background(), http.NotFoundHandler(), config, "test-cold-start")
+		assert.NoError(t, err)
+		assert.Equal(t, "60", handler.(*Middleware).coldStartRetryAfter)
+	})
+
+	t.Run("invalid duration is rejected", func(t *testing.T) {
+		config.ColdStartRetryAfter = "soon"
+		_, err := New(context.Background(), http.NotFoundHandler(), config, "test-cold-start")
+		assert.ErrorContains(t, err, "invalid cold_start_retry_after duration")
+	})
+}
@@ -1,7 +1,12 @@
 package flecto_traefik_middleware
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/flectolab/flecto-manager/common/types"
@@ -14,17 +19,61 @@ type ClientSettings struct {
 	NamespaceCode string `json:"namespace_code" mapstructure:"namespace_code"`
 	ProjectCode   string `json:"project_code" mapstructure:"project_code"`
 
+	// ManagerUrls lists fallback managers, tried in order when the primary fails.
+	// ManagerUrl, when set, is the primary; otherwise the first entry is.
+	ManagerUrls []string `json:"manager_urls" mapstructure:"manager_urls"`
+
 	HeaderAuthorizationName string `json:"header_authorization_name" mapstructure:"header_authorization_name"`
 	TokenJWT                string `json:"token_jwt" mapstructure:"token_jwt"`
+	// TokenJWTNext is tried when the manager rejects TokenJWT, to rotate tokens without downtime.
+	TokenJWTNext string `json:"token_jwt_next" mapstructure:"token_jwt_next"`
+
+	// ManagerHeaders are sent with every request to the manager, e.g. to serve region specific rules.
+	ManagerHeaders map[string]string `json:"manager_headers" mapstructure:"manager_headers"`
+
+	// MinTLSVersion is the lowest TLS version accepted from the manager: 1.0, 1.1, 1.2 or 1.3.
+	MinTLSVersion string `json:"min_tls_version" mapstructure:"min_tls_version"`
 
 	IntervalCheck string `json:"interval_check" mapstructure:"interval_check"`
 	AgentName     string `json:"agent_name" mapstructure:"agent_name"`
+
+	// StripPrefix is trimmed from the request path before matching the rules, authored relative to it.
+	// RestorePrefix re-adds it to the relative targets of the matched redirects.
+	StripPrefix   string `json:"strip_prefix" mapstructure:"strip_prefix"`
+	RestorePrefix bool   `json:"restore_prefix" mapstructure:"restore_prefix"`
 }
 
 // HostConfig holds the configuration for specific hosts.
 type HostConfig struct {
 	Hosts          []string `json:"hosts" mapstructure:"hosts"` // required
 	ClientSettings `mapstructure:",squash"`
+
+	// MaintenanceMode answers every request with MaintenancePage and a 503, bypassing rule matching.
+	MaintenanceMode       bool   `json:"maintenance_mode" mapstructure:"maintenance_mode"`
+	MaintenancePage       string `json:"maintenance_page" mapstructure:"maintenance_page"`
+	MaintenanceRetryAfter string `json:"maintenance_retry_after" mapstructure:"maintenance_retry_after"`
+	// ExemptPaths are passed through during maintenance, entries ending with * match as prefix.
+	ExemptPaths []string `json:"exempt_paths" mapstructure:"exempt_paths"`
+
+	// ExtraPages are matched before the pages of the client, for these hosts only.
+	ExtraPages []types.Page `json:"extra_pages" mapstructure:"extra_pages"`
+	// PageRewrites serves the page of the target path for the source path, without redirecting.
+	PageRewrites map[string]string `json:"page_rewrites" mapstructure:"page_rewrites"`
+
+	// MigrateTo moves MigrateWeight percent of the clients of these hosts to another project, inheriting like ClientSettings.
+	// A client always gets the same project, based on its IP address.
+	MigrateTo     *ClientSettings `json:"migrate_to" mapstructure:"migrate_to"`
+	MigrateWeight int             `json:"migrate_weight" mapstructure:"migrate_weight"`
+
+	// ForceHTTPS redirects plain HTTP requests of these hosts to HTTPS, it is always enabled by the root option.
+	ForceHTTPS bool `json:"force_https" mapstructure:"force_https"`
+
+	// ResponseHeaders are added to every response of these hosts, passed through or not, unless the response sets them.
+	ResponseHeaders map[string]string `json:"response_headers" mapstructure:"response_headers"`
+
+	// Default makes the client of this host config serve the hosts without host config, in place of project_code.
+	// The other options of the host config still only apply to its hosts.
+	Default bool `json:"default" mapstructure:"default"`
 }
 
 // Config holds the plugin configuration.
@@ -32,6 +81,140 @@ type Config struct {
 	ClientSettings `mapstructure:",squash"`
 	Debug          bool         `json:"debug" mapstructure:"debug"`
 	HostConfigs    []HostConfig `json:"host_configs" mapstructure:"host_configs"`
+
+	// SecondaryDefault serves the hosts of the default client while it has not loaded any rules yet,
+	// e.g. from a read-only replica of the manager. It inherits from ClientSettings like a host config.
+	SecondaryDefault *ClientSettings `json:"secondary_default" mapstructure:"secondary_default"`
+
+	// SlowMatchThreshold logs rule matchings taking longer than this duration when debug is enabled.
+	SlowMatchThreshold string `json:"slow_match_threshold" mapstructure:"slow_match_threshold"`
+
+	// AccessLog logs one line per request with the decision taken by the middleware.
+	AccessLog bool `json:"access_log" mapstructure:"access_log"`
+
+	// DebugTrailer emits the matched rule as the X-Middleware-Flecto-Rule trailer when debug is enabled.
+	DebugTrailer bool `json:"debug_trailer" mapstructure:"debug_trailer"`
+
+	// PreserveRedirectTarget sets the redirect target as is in the Location header, instead of percent-encoding it.
+	PreserveRedirectTarget bool `json:"preserve_redirect_target" mapstructure:"preserve_redirect_target"`
+	// DefaultRedirectCode is used for redirects with an unrecognized status, 302 when unset.
+	DefaultRedirectCode int `json:"default_redirect_code" mapstructure:"default_redirect_code"`
+	// DefaultPageContentType is served for pages with an empty or unknown content type, text/plain when unset.
+	DefaultPageContentType string `json:"default_page_content_type" mapstructure:"default_page_content_type"`
+	// StaleWhileRevalidate is added to the Cache-Control of pages, so caches may serve them stale while refreshing.
+	StaleWhileRevalidate string `json:"stale_while_revalidate" mapstructure:"stale_while_revalidate"`
+	// ChunkedPageThreshold streams pages of at least this many bytes with chunked encoding, without Content-Length.
+	ChunkedPageThreshold int64 `json:"chunked_page_threshold" mapstructure:"chunked_page_threshold"`
+	// DefaultRobotsTxt is served for /robots.txt when no rule matches it.
+	DefaultRobotsTxt string `json:"default_robots_txt" mapstructure:"default_robots_txt"`
+	// DefaultFavicon serves an empty /favicon.ico when no rule matches it.
+	DefaultFavicon bool `json:"default_favicon" mapstructure:"default_favicon"`
+	// SkipEmptyPages ignores pages without content, as malformed rules, instead of serving an empty body.
+	SkipEmptyPages bool `json:"skip_empty_pages" mapstructure:"skip_empty_pages"`
+	// CapturePageStatuses are the statuses of the next handler answered with the page of CapturePagePath instead,
+	// e.g. 404 and 410 for a branded "content removed" page. The status is kept.
+	CapturePageStatuses []int  `json:"capture_page_statuses" mapstructure:"capture_page_statuses"`
+	CapturePagePath     string `json:"capture_page_path" mapstructure:"capture_page_path"`
+	// MatchPathOnly retries redirect matching with the path alone when the full uri does not match.
+	MatchPathOnly bool `json:"match_path_only" mapstructure:"match_path_only"`
+
+	// StaleAfter disables the rules of a client when its last successful reload is older than this duration.
+	StaleAfter string `json:"stale_after" mapstructure:"stale_after"`
+	// StaleStatus is returned instead of passing through when the rules are stale.
+	StaleStatus int `json:"stale_status" mapstructure:"stale_status"`
+
+	// ReadyHosts limits the clients waited for by ReadyHandler to those serving these hosts, every client when empty.
+	ReadyHosts []string `json:"ready_hosts" mapstructure:"ready_hosts"`
+
+	// ColdStartPage is served with a 503 instead of passing through while the client of a host has not loaded any rules yet.
+	ColdStartPage string `json:"cold_start_page" mapstructure:"cold_start_page"`
+	// ColdStartRetryAfter is sent in the Retry-After header of the cold start page.
+	ColdStartRetryAfter string `json:"cold_start_retry_after" mapstructure:"cold_start_retry_after"`
+
+	// ServeTimeout bounds the rule matching of a request, which is then passed through or answered with ServeTimeoutStatus.
+	ServeTimeout       string `json:"serve_timeout" mapstructure:"serve_timeout"`
+	ServeTimeoutStatus int    `json:"serve_timeout_status" mapstructure:"serve_timeout_status"`
+
+	// RedirectsDisabled and PagesDisabled pass the requests of the redirects or the pages of the rules through.
+	// Disabling one keeps the other working, e.g. to pass pages through during a content migration.
+	RedirectsDisabled bool `json:"redirects_disabled" mapstructure:"redirects_disabled"`
+	PagesDisabled     bool `json:"pages_disabled" mapstructure:"pages_disabled"`
+
+	// MatchOrder selects whether redirects (redirect-first, default) or pages (page-first) are matched first.
+	MatchOrder string `json:"match_order" mapstructure:"match_order"`
+	// ConflictPolicy selects what is served when a redirect and a page match the same uri, replacing MatchOrder:
+	// redirect-wins (default), page-wins or error, which logs the conflict and passes the request through.
+	ConflictPolicy string `json:"conflict_policy" mapstructure:"conflict_policy"`
+
+	// MatchMode selects the uri passed to the rule matching: raw (escaped, default) or decoded.
+	MatchMode string `json:"match_mode" mapstructure:"match_mode"`
+
+	// ForceHTTPS redirects plain HTTP requests to HTTPS with a 308 before rule matching.
+	ForceHTTPS bool `json:"force_https" mapstructure:"force_https"`
+	// ForceHTTPSExemptPaths are never upgraded, ACME challenges are always exempt.
+	ForceHTTPSExemptPaths []string `json:"force_https_exempt_paths" mapstructure:"force_https_exempt_paths"`
+	// TrustForwardedHeaders uses X-Forwarded-Proto to detect HTTPS requests terminated by a front proxy.
+	TrustForwardedHeaders bool `json:"trust_forwarded_headers" mapstructure:"trust_forwarded_headers"`
+
+	// RolloutHash buckets the clients of weighted rollouts such as migrate_weight: fnv (default), crc32 or sha256-mod.
+	RolloutHash string `json:"rollout_hash" mapstructure:"rollout_hash"`
+
+	// MatchScheme first matches redirect rules against scheme://host, so host rules can depend on the scheme.
+	MatchScheme bool `json:"match_scheme" mapstructure:"match_scheme"`
+	// MatchMethod first matches redirect rules against the method followed by the uri (GET /path), so rules can depend on the method.
+	MatchMethod bool `json:"match_method" mapstructure:"match_method"`
+
+	// BypassPaths always go to the next handler untouched, entries ending with * match as prefix.
+	BypassPaths []string `json:"bypass_paths" mapstructure:"bypass_paths"`
+
+	// SyntheticErrorFormat is the body format of the errors generated by the middleware, text or json.
+	SyntheticErrorFormat string `json:"synthetic_error_format" mapstructure:"synthetic_error_format"`
+
+	// MaxRequestBodyBytes caps the request body read by the next handler when passing through.
+	MaxRequestBodyBytes int64 `json:"max_request_body_bytes" mapstructure:"max_request_body_bytes"`
+
+	// MaxUriLength passes requests with a longer uri through without matching, or answers a 414 with MaxUriLengthStrict.
+	MaxUriLength       int  `json:"max_uri_length" mapstructure:"max_uri_length"`
+	MaxUriLengthStrict bool `json:"max_uri_length_strict" mapstructure:"max_uri_length_strict"`
+
+	// ReloadFailureThreshold consecutive reload failures of a client pause its reloads for ReloadCooldown.
+	ReloadFailureThreshold int    `json:"reload_failure_threshold" mapstructure:"reload_failure_threshold"`
+	ReloadCooldown         string `json:"reload_cooldown" mapstructure:"reload_cooldown"`
+
+	// InitRetries retries a failed initial load of a client this many times before leaving it to the reload ticker.
+	// The clients are initialized one after the other, so New blocks for up to the sum of the backoffs for each
+	// failing client, InitRetryBackoff doubling after each attempt.
+	InitRetries      int    `json:"init_retries" mapstructure:"init_retries"`
+	InitRetryBackoff string `json:"init_retry_backoff" mapstructure:"init_retry_backoff"`
+
+	// SharedClients shares the clients of identical settings with the other middlewares of the process,
+	// so a project is reloaded once whatever the number of middlewares using it.
+	SharedClients bool `json:"shared_clients" mapstructure:"shared_clients"`
+
+	// MinIntervalCheck is the lowest interval_check allowed, for the root and every host config.
+	MinIntervalCheck string `json:"min_interval_check" mapstructure:"min_interval_check"`
+
+	// AdminToken is required in the X-Flecto-Admin-Token header by AdminHandler when set.
+	AdminToken string `json:"admin_token" mapstructure:"admin_token"`
+
+	// DumpConfig logs the effective settings of each host on startup, with secrets redacted.
+	DumpConfig bool `json:"dump_config" mapstructure:"dump_config"`
+
+	// OnReload is called after each reload attempt of a client, with its settings key and state version.
+	// Like every key exposed by the middleware, the key has the manager url passwords redacted.
+	// It is only available when embedding the middleware, as it cannot be set from Traefik configuration.
+	OnReload func(key string, version int, err error) `json:"-" mapstructure:"-"`
+	// Events receives the decision taken for each request, events are dropped when it is full so serving never blocks.
+	// It is only available when embedding the middleware, as it cannot be set from Traefik configuration.
+	Events chan<- MatchEvent `json:"-" mapstructure:"-"`
+
+	// Metrics counts the redirects, pages and passthroughs, labeled according to MetricsLabel.
+	// It is only available when embedding the middleware, as it cannot be set from Traefik configuration.
+	Metrics MetricsRecorder `json:"-" mapstructure:"-"`
+	// MetricsLabel labels the metrics by host (default) or by project, which bounds the cardinality to the clients.
+	MetricsLabel string `json:"metrics_label" mapstructure:"metrics_label"`
+	// OpenMetrics counts the decisions for MetricsHandler, which exposes them in the OpenMetrics text format.
+	OpenMetrics bool `json:"open_metrics" mapstructure:"open_metrics"`
 }
 
 // CreateConfig creates the default plugin configuration.
@@ -45,8 +228,10 @@ func CreateConfig() *Config {
 // Note: AgentName is always inherited from parent and cannot be overridden.
 func mergeSettings(parent, override ClientSettings) ClientSettings {
 	result := parent
-	if override.ManagerUrl != "" {
+	// Manager URLs are overridden together, fallbacks of the parent are not kept for another manager
+	if override.ManagerUrl != "" || len(override.ManagerUrls) > 0 {
 		result.ManagerUrl = override.ManagerUrl
+		result.ManagerUrls = override.ManagerUrls
 	}
 	if override.NamespaceCode != "" {
 		result.NamespaceCode = override.NamespaceCode
@@ -56,23 +241,109 @@ func mergeSettings(parent, override ClientSettings) ClientSettings {
 	if override.HeaderAuthorizationName != "" {
 		result.HeaderAuthorizationName = override.HeaderAuthorizationName
 	}
-	if override.TokenJWT != "" {
+	// Tokens are overridden together, the next token of the parent belongs to the parent token
+	if override.TokenJWT != "" || override.TokenJWTNext != "" {
 		result.TokenJWT = override.TokenJWT
+		result.TokenJWTNext = override.TokenJWTNext
+	}
+	// Headers are overridden together, as a whole map
+	if len(override.ManagerHeaders) > 0 {
+		result.ManagerHeaders = override.ManagerHeaders
+	}
+	if override.MinTLSVersion != "" {
+		result.MinTLSVersion = override.MinTLSVersion
 	}
 	if override.IntervalCheck != "" {
 		result.IntervalCheck = override.IntervalCheck
 	}
+	// The prefix is overridden together with whether it is restored on the targets
+	if override.StripPrefix != "" {
+		result.StripPrefix = override.StripPrefix
+		result.RestorePrefix = override.RestorePrefix
+	}
 	// AgentName is always inherited from parent and cannot be overridden
 	result.AgentName = parent.AgentName
 	return result
 }
 
+// redactedSecret replaces secrets in logged or exposed settings
+const redactedSecret = "***"
+
+// redactSettings returns settings with the tokens, the manager header values and the manager url passwords redacted.
+func redactSettings(settings ClientSettings) ClientSettings {
+	if settings.TokenJWT != "" {
+		settings.TokenJWT = redactedSecret
+	}
+	if settings.TokenJWTNext != "" {
+		settings.TokenJWTNext = redactedSecret
+	}
+	settings.ManagerUrl = redactURL(settings.ManagerUrl)
+	if len(settings.ManagerUrls) > 0 {
+		urls := make([]string, len(settings.ManagerUrls))
+		for i, u := range settings.ManagerUrls {
+			urls[i] = redactURL(u)
+		}
+		settings.ManagerUrls = urls
+	}
+	if len(settings.ManagerHeaders) > 0 {
+		// Header values may carry credentials, such as an Authorization header
+		headers := make(map[string]string, len(settings.ManagerHeaders))
+		for name := range settings.ManagerHeaders {
+			headers[name] = redactedSecret
+		}
+		settings.ManagerHeaders = headers
+	}
+	return settings
+}
+
+// redactURL hides the password of a url, invalid urls are returned as is.
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.User == nil {
+		return rawURL
+	}
+	return u.Redacted()
+}
+
+// managerUrls returns the manager URLs in failover order, ManagerUrl being the primary when set.
+func managerUrls(settings ClientSettings) []string {
+	urls := make([]string, 0, len(settings.ManagerUrls)+1)
+	if settings.ManagerUrl != "" {
+		urls = append(urls, settings.ManagerUrl)
+	}
+	for _, u := range settings.ManagerUrls {
+		if u == "" || u != settings.ManagerUrl {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// DefaultNamespaceCode is used by the configurations without namespace_code.
+// It is read from the FLECTO_NAMESPACE_CODE environment variable, embeddings may also set it before calling New.
+var DefaultNamespaceCode = os.Getenv("FLECTO_NAMESPACE_CODE")
+
+// namespaceCode returns the namespace of settings, DefaultNamespaceCode when it is not configured.
+func namespaceCode(settings ClientSettings) string {
+	if settings.NamespaceCode != "" {
+		return settings.NamespaceCode
+	}
+	return DefaultNamespaceCode
+}
+
 func transformSettings(name string, settings ClientSettings) (*client.Config, error) {
 	clientCfg := client.NewDefaultConfig()
-	if settings.ManagerUrl == "" || settings.NamespaceCode == "" || settings.ProjectCode == "" || settings.TokenJWT == "" {
+	urls := managerUrls(settings)
+	settings.NamespaceCode = namespaceCode(settings)
+	if len(urls) == 0 || settings.NamespaceCode == "" || settings.ProjectCode == "" || settings.TokenJWT == "" {
 		return nil, fmt.Errorf("%s: missing configuration, manager_url, namespace_code, project_code or token_jwt is mandatory", name)
 	}
-	clientCfg.ManagerUrl = settings.ManagerUrl
+	for i, u := range urls {
+		if u == "" {
+			return nil, fmt.Errorf("%s: invalid configuration, manager_urls[%d] is empty", name, i)
+		}
+	}
+	clientCfg.ManagerUrl = urls[0]
 	clientCfg.NamespaceCode = settings.NamespaceCode
 	clientCfg.ProjectCode = settings.ProjectCode
 	clientCfg.Http.TokenJWT = settings.TokenJWT
@@ -83,9 +354,54 @@ func transformSettings(name string, settings ClientSettings) (*client.Config, er
 	}
 
 	if settings.HeaderAuthorizationName != "" {
+		if !isHTTPToken(settings.HeaderAuthorizationName) {
+			return nil, fmt.Errorf("%s: invalid configuration, header_authorization_name %q is not a valid header name", name, settings.HeaderAuthorizationName)
+		}
 		clientCfg.Http.HeaderAuthorizationName = settings.HeaderAuthorizationName
 	}
 
+	if settings.MinTLSVersion != "" {
+		minVersion, ok := tlsVersions[settings.MinTLSVersion]
+		if !ok {
+			return nil, fmt.Errorf("%s: invalid configuration, min_tls_version %q must be 1.0, 1.1, 1.2 or 1.3", name, settings.MinTLSVersion)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{MinVersion: minVersion}
+		clientCfg.Http.Client = &http.Client{Transport: transport}
+	}
+
+	if settings.StripPrefix != "" && (!strings.HasPrefix(settings.StripPrefix, "/") || strings.HasSuffix(settings.StripPrefix, "/")) {
+		return nil, fmt.Errorf("%s: invalid configuration, strip_prefix %q must start with / and not end with /", name, settings.StripPrefix)
+	}
+	if settings.RestorePrefix && settings.StripPrefix == "" {
+		return nil, fmt.Errorf("%s: invalid configuration, restore_prefix requires strip_prefix", name)
+	}
+
+	if len(settings.ManagerHeaders) > 0 {
+		headers := make(http.Header, len(settings.ManagerHeaders))
+		for headerName, value := range settings.ManagerHeaders {
+			if !isHTTPToken(headerName) || strings.ContainsAny(value, "\r\n") {
+				return nil, fmt.Errorf("%s: invalid configuration, manager_headers %q is not a valid header", name, headerName)
+			}
+			if strings.EqualFold(headerName, clientCfg.Http.HeaderAuthorizationName) {
+				return nil, fmt.Errorf("%s: invalid configuration, manager_headers cannot set the authorization header %q", name, headerName)
+			}
+			headers.Set(headerName, value)
+		}
+		clientCfg.Http.Client = &headerClient{next: clientCfg.Http.Client, headers: headers}
+	}
+
+	if settings.TokenJWTNext != "" {
+		clientCfg.Http.Client = &tokenRotationClient{
+			next:       clientCfg.Http.Client,
+			headerName: clientCfg.Http.HeaderAuthorizationName,
+			nextToken:  settings.TokenJWTNext,
+		}
+	}
+	if len(urls) > 1 {
+		clientCfg.Http.Client = &failoverClient{next: clientCfg.Http.Client, urls: urls}
+	}
+
 	if settings.IntervalCheck != "" {
 		intervalCheck, err := time.ParseDuration(settings.IntervalCheck)
 		if err != nil {
@@ -96,6 +412,41 @@ func transformSettings(name string, settings ClientSettings) (*client.Config, er
 	return clientCfg, nil
 }
 
+// tlsVersions maps the values of min_tls_version to their crypto/tls version
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// isHTTPToken reports whether s is a valid header field name, a token as defined by RFC 9110.
+func isHTTPToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		isAlnum := c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		if !isAlnum && !strings.ContainsRune("!#$%&'*+-.^_`|~", rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOptionalDuration parses a duration option, an empty value means disabled.
+func parseOptionalDuration(option, value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s duration (%v)", option, err)
+	}
+	return d, nil
+}
+
 // validateConfig validates the plugin configuration.
 func validateConfig(config *Config) error {
 	// Must have either a default ProjectCode or at least one HostConfig
@@ -103,13 +454,183 @@ func validateConfig(config *Config) error {
 		return fmt.Errorf("either project_code or host_configs must be configured")
 	}
 
+	if config.SecondaryDefault != nil {
+		if config.ProjectCode == "" {
+			return fmt.Errorf("secondary_default requires project_code")
+		}
+		if config.SecondaryDefault.ProjectCode == "" {
+			return fmt.Errorf("secondary_default: project_code is required")
+		}
+	}
+
+	if config.DefaultRedirectCode != 0 && !isRedirectCode(config.DefaultRedirectCode) {
+		return fmt.Errorf("default_redirect_code must be one of 301, 302, 303, 307 or 308")
+	}
+	if config.StaleStatus != 0 && (config.StaleStatus < 400 || config.StaleStatus > 599) {
+		return fmt.Errorf("stale_status must be a 4xx or 5xx status code")
+	}
+	if config.ServeTimeoutStatus != 0 && (config.ServeTimeoutStatus < 400 || config.ServeTimeoutStatus > 599) {
+		return fmt.Errorf("serve_timeout_status must be a 4xx or 5xx status code")
+	}
+	if config.MatchOrder != "" && config.MatchOrder != matchOrderRedirectFirst && config.MatchOrder != matchOrderPageFirst {
+		return fmt.Errorf("match_order must be redirect-first or page-first")
+	}
+	if config.ConflictPolicy != "" && config.ConflictPolicy != conflictPolicyRedirectWins &&
+		config.ConflictPolicy != conflictPolicyPageWins && config.ConflictPolicy != conflictPolicyError {
+		return fmt.Errorf("conflict_policy must be redirect-wins, page-wins or error")
+	}
+	if config.ConflictPolicy != "" && config.MatchOrder != "" {
+		return fmt.Errorf("conflict_policy and match_order cannot be both set")
+	}
+	if config.MatchMode != "" && config.MatchMode != matchModeRaw && config.MatchMode != matchModeDecoded {
+		return fmt.Errorf("match_mode must be raw or decoded")
+	}
+	if config.MetricsLabel != "" && config.MetricsLabel != metricsLabelHost && config.MetricsLabel != metricsLabelProject {
+		return fmt.Errorf("metrics_label must be host or project")
+	}
+	if !isRolloutHash(config.RolloutHash) {
+		return fmt.Errorf("rollout_hash must be fnv, crc32 or sha256-mod")
+	}
+	if !isSyntheticErrorFormat(config.SyntheticErrorFormat) {
+		return fmt.Errorf("synthetic_error_format must be text or json")
+	}
+	if config.ReloadFailureThreshold < 0 {
+		return fmt.Errorf("reload_failure_threshold must not be negative")
+	}
+	if config.MaxRequestBodyBytes < 0 {
+		return fmt.Errorf("max_request_body_bytes must not be negative")
+	}
+	if config.MaxUriLength < 0 {
+		return fmt.Errorf("max_uri_length must not be negative")
+	}
+	if config.MaxUriLengthStrict && config.MaxUriLength == 0 {
+		return fmt.Errorf("max_uri_length_strict requires max_uri_length")
+	}
+	if config.InitRetries < 0 {
+		return fmt.Errorf("init_retries must not be negative")
+	}
+	if config.ChunkedPageThreshold < 0 {
+		return fmt.Errorf("chunked_page_threshold must not be negative")
+	}
+	for _, status := range config.CapturePageStatuses {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("capture_page_statuses must be 4xx or 5xx status codes")
+		}
+	}
+	if len(config.CapturePageStatuses) > 0 && !strings.HasPrefix(config.CapturePagePath, "/") {
+		return fmt.Errorf("capture_page_path must start with / when capture_page_statuses is set")
+	}
+	minIntervalCheck, err := parseOptionalDuration("min_interval_check", config.MinIntervalCheck)
+	if err != nil {
+		return err
+	}
+	if minIntervalCheck > 0 && config.ProjectCode != "" {
+		if err := checkMinIntervalCheck(config.ClientSettings, minIntervalCheck); err != nil {
+			return err
+		}
+	}
+	if minIntervalCheck > 0 && config.SecondaryDefault != nil {
+		if err := checkMinIntervalCheck(mergeSettings(config.ClientSettings, *config.SecondaryDefault), minIntervalCheck); err != nil {
+			return fmt.Errorf("secondary_default: %w", err)
+		}
+	}
+
+	defaultHostConfig := -1
 	for i, hc := range config.HostConfigs {
+		if hc.Default {
+			if config.ProjectCode != "" {
+				return fmt.Errorf("host_configs[%d]: default cannot be set with project_code", i)
+			}
+			if defaultHostConfig != -1 {
+				return fmt.Errorf("host_configs[%d]: default is already set on host_configs[%d]", i, defaultHostConfig)
+			}
+			defaultHostConfig = i
+		}
 		if len(hc.Hosts) == 0 {
 			return fmt.Errorf("host_configs[%d]: hosts is required and cannot be empty", i)
 		}
 		if hc.ProjectCode == "" {
 			return fmt.Errorf("host_configs[%d]: project_code is required", i)
 		}
+		if err := validateHostPolicy(i, hc); err != nil {
+			return err
+		}
+		if minIntervalCheck > 0 {
+			if err := checkMinIntervalCheck(mergeSettings(config.ClientSettings, hc.ClientSettings), minIntervalCheck); err != nil {
+				return fmt.Errorf("host_configs[%d]: %w", i, err)
+			}
+			if hc.MigrateTo != nil {
+				if err := checkMinIntervalCheck(mergeSettings(config.ClientSettings, *hc.MigrateTo), minIntervalCheck); err != nil {
+					return fmt.Errorf("host_configs[%d]: migrate_to: %w", i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Validate checks the configuration as New does, without creating any client.
+func (c *Config) Validate() error {
+	return validateConfig(c)
+}
+
+// EffectiveSettings returns the client settings used for host, inherited from the root settings as New does.
+// It returns false when no host config lists host and the root configuration has no project_code.
+// Settings are returned with their secrets, use Redacted before displaying them.
+func (c *Config) EffectiveSettings(host string) (ClientSettings, bool) {
+	host = hostname(host)
+	var settings ClientSettings
+	found := false
+	// Later host configs win, as their clients replace the earlier ones in New
+	for _, hc := range c.HostConfigs {
+		for _, h := range hc.Hosts {
+			if h == host {
+				settings, found = mergeSettings(c.ClientSettings, hc.ClientSettings), true
+			}
+		}
+	}
+	if !found {
+		settings, found = c.defaultSettings()
+		if !found {
+			return ClientSettings{}, false
+		}
+	}
+	settings.NamespaceCode = namespaceCode(settings)
+	return settings, true
+}
+
+// defaultSettings returns the settings of the client serving the hosts without host config,
+// from project_code or the host config marked default.
+func (c *Config) defaultSettings() (ClientSettings, bool) {
+	if c.ProjectCode != "" {
+		return c.ClientSettings, true
+	}
+	for _, hc := range c.HostConfigs {
+		if hc.Default {
+			return mergeSettings(c.ClientSettings, hc.ClientSettings), true
+		}
+	}
+	return ClientSettings{}, false
+}
+
+// Redacted returns a copy of the settings with tokens and manager url passwords hidden.
+func (s ClientSettings) Redacted() ClientSettings {
+	return redactSettings(s)
+}
+
+// checkMinIntervalCheck ensures the effective interval_check of settings is not below minIntervalCheck.
+// An empty interval_check uses the client default.
+func checkMinIntervalCheck(settings ClientSettings, minIntervalCheck time.Duration) error {
+	intervalCheck := client.NewDefaultConfig().IntervalCheck
+	if settings.IntervalCheck != "" {
+		d, err := time.ParseDuration(settings.IntervalCheck)
+		if err != nil {
+			return fmt.Errorf("invalid interval check duration (%v)", err)
+		}
+		intervalCheck = d
+	}
+	if intervalCheck < minIntervalCheck {
+		return fmt.Errorf("interval_check %s is below min_interval_check %s", intervalCheck, minIntervalCheck)
 	}
 	return nil
 }
@@ -0,0 +1,40 @@
+// Package configfile loads the middleware configuration from a file, for embeddings outside of Traefik.
+// It is kept out of the plugin package so that Traefik does not have to load a YAML decoder.
+package configfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	middleware "github.com/flectolab/flecto-traefik-middleware"
+	"gopkg.in/yaml.v3"
+)
+
+// Load reads a Config from a JSON or YAML file.
+// Options use the same names as in Traefik configuration, unset ones keep the CreateConfig defaults.
+func Load(path string) (*middleware.Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// JSON is valid YAML, both are decoded to a generic document then mapped through the json tags
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	raw, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	config := middleware.CreateConfig()
+	if err := json.Unmarshal(raw, config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return config, nil
+}
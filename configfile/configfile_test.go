@@ -0,0 +1,111 @@
+package configfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	middleware "github.com/flectolab/flecto-traefik-middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	want := &middleware.Config{
+		ClientSettings: middleware.ClientSettings{
+			ManagerUrl:    "https://flecto-manager.example.com",
+			NamespaceCode: "my-namespace",
+			ProjectCode:   "my-project",
+			TokenJWT:      "token",
+			IntervalCheck: "1m",
+		},
+		Debug:       true,
+		BypassPaths: []string{"/healthz"},
+		HostConfigs: []middleware.HostConfig{
+			{
+				Hosts:           []string{"example.fr", "www.example.fr"},
+				ClientSettings:  middleware.ClientSettings{ProjectCode: "project-fr", IntervalCheck: "2m"},
+				MaintenanceMode: true,
+			},
+		},
+	}
+
+	t.Run("yaml", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", `
+manager_url: "https://flecto-manager.example.com"
+namespace_code: "my-namespace"
+project_code: "my-project"
+token_jwt: "token"
+interval_check: "1m"
+debug: true
+bypass_paths:
+  - "/healthz"
+host_configs:
+  - hosts:
+      - "example.fr"
+      - "www.example.fr"
+    project_code: "project-fr"
+    interval_check: "2m"
+    maintenance_mode: true
+`)
+		config, err := Load(path)
+		assert.NoError(t, err)
+		assert.Equal(t, want, config)
+	})
+
+	t.Run("json", func(t *testing.T) {
+		path := writeConfigFile(t, "config.json", `{
+  "manager_url": "https://flecto-manager.example.com",
+  "namespace_code": "my-namespace",
+  "project_code": "my-project",
+  "token_jwt": "token",
+  "interval_check": "1m",
+  "debug": true,
+  "bypass_paths": ["/healthz"],
+  "host_configs": [
+    {"hosts": ["example.fr", "www.example.fr"], "project_code": "project-fr", "interval_check": "2m", "maintenance_mode": true}
+  ]
+}`)
+		config, err := Load(path)
+		assert.NoError(t, err)
+		assert.Equal(t, want, config)
+	})
+
+	t.Run("defaults can be overridden", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", `
+manager_url: "https://flecto-manager.example.com"
+namespace_code: "my-namespace"
+project_code: "my-project"
+token_jwt: "token"
+preserve_redirect_target: true
+`)
+		config, err := Load(path)
+		assert.NoError(t, err)
+		assert.True(t, config.PreserveRedirectTarget)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", `manager_url: "https://flecto-manager.example.com"`)
+		_, err := Load(path)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "either project_code or host_configs must be configured")
+	})
+
+	t.Run("malformed file", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", "project_code: [")
+		_, err := Load(path)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), path)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := Load(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
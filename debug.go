@@ -0,0 +1,75 @@
+package flecto_traefik_middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/flectolab/go-client"
+)
+
+// requestIDHeader is propagated in debug headers and logs to correlate a match with downstream logs
+const requestIDHeader = "X-Request-Id"
+
+// testURIHeader makes the middleware match another uri than the request one when debug is enabled.
+// The match result is only reported in headers, nothing is redirected or served.
+const testURIHeader = "X-Flecto-Test-Uri"
+
+// serveTestURI reports the match result of rawURI in headers and returns the status written.
+func (m *Middleware) serveTestURI(rw http.ResponseWriter, req *http.Request, c client.Client, policy *hostPolicy, rawURI string) int {
+	u, err := url.ParseRequestURI(rawURI)
+	if err != nil {
+		writeSyntheticError(rw, m.syntheticErrorFormat, http.StatusBadRequest, "invalid "+testURIHeader+" header")
+		return http.StatusBadRequest
+	}
+
+	uri := m.ruleURI(u)
+	h := rw.Header()
+	addVary(h, testURIHeader)
+	h.Set("X-Middleware-Flecto-Test-Uri", uri)
+	redirect, target, page := m.matchRules(c, policy, req, uri, u)
+	if redirect != nil {
+		h.Set("X-Middleware-Flecto-Test-Result", "redirect")
+		h.Set("X-Middleware-Flecto-Test-Status", strconv.Itoa(redirectCode(redirect, m.defaultRedirectCode)))
+		h.Set("X-Middleware-Flecto-Test-Location", target)
+	} else if page != nil {
+		h.Set("X-Middleware-Flecto-Test-Result", "page")
+		h.Set("X-Middleware-Flecto-Test-Content-Type", pageContentType(page, m.defaultPageContentType))
+	} else {
+		h.Set("X-Middleware-Flecto-Test-Result", "passthrough")
+	}
+	rw.WriteHeader(http.StatusNoContent)
+	return http.StatusNoContent
+}
+
+// serverTiming formats the match duration as a Server-Timing entry, in milliseconds.
+func serverTiming(d time.Duration) string {
+	return "flecto;dur=" + strconv.FormatFloat(float64(d)/float64(time.Millisecond), 'f', 3, 64)
+}
+
+// logSlowMatch logs a rule matching that took longer than slow_match_threshold.
+func (m *Middleware) logSlowMatch(kind, host, uri string, d time.Duration) {
+	if m.slowMatchThreshold > 0 && d > m.slowMatchThreshold {
+		m.logf("Slow %s match for %s%s: %s", kind, host, uri, d)
+	}
+}
+
+// requestID returns the X-Request-Id of req, or a new random id when it has none.
+func requestID(req *http.Request) string {
+	if id := req.Header.Get(requestIDHeader); id != "" {
+		return id
+	}
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// traceMatch reports a matched rule with the request id, in a debug header and in the logs.
+func (m *Middleware) traceMatch(rw http.ResponseWriter, req *http.Request, uri, match string) {
+	id := requestID(req)
+	rw.Header().Set("X-Middleware-Flecto-Request-Id", id)
+	m.logf("request %s: %s%s matched %s", id, req.Host, uri, match)
+}
@@ -0,0 +1,314 @@
+package flecto_traefik_middleware
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_TestURI(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri != "/old" {
+				return nil, ""
+			}
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: "/old",
+				Target: "/new",
+				Status: types.RedirectStatusMovedPermanent,
+			}, "/new"
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/sitemap.xml" {
+				return nil
+			}
+			return &types.Page{
+				Type:        types.PageTypeBasic,
+				Path:        "/sitemap.xml",
+				Content:     "<urlset></urlset>",
+				ContentType: types.PageContentTypeXML,
+			}
+		},
+	}
+	newMiddleware := func(debug bool, nextCalled *bool) *Middleware {
+		return &Middleware{
+			name: "test",
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			}),
+			debug:         debug,
+			defaultClient: mock,
+			hostClients:   make(map[string]client.Client),
+		}
+	}
+
+	tests := []struct {
+		name         string
+		testURI      string
+		wantStatus   int
+		wantHeaders  map[string]string
+		wantNoHeader string
+	}{
+		{
+			name:       "reports redirect without redirecting",
+			testURI:    "/old",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"X-Middleware-Flecto-Test-Uri":      "/old",
+				"X-Middleware-Flecto-Test-Result":   "redirect",
+				"X-Middleware-Flecto-Test-Status":   "301",
+				"X-Middleware-Flecto-Test-Location": "/new",
+			},
+			wantNoHeader: "Location",
+		},
+		{
+			name:       "reports page without serving it",
+			testURI:    "/sitemap.xml",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"X-Middleware-Flecto-Test-Result":       "page",
+				"X-Middleware-Flecto-Test-Content-Type": "application/xml",
+			},
+		},
+		{
+			name:       "reports passthrough",
+			testURI:    "/unknown",
+			wantStatus: http.StatusNoContent,
+			wantHeaders: map[string]string{
+				"X-Middleware-Flecto-Test-Result": "passthrough",
+			},
+		},
+		{
+			name:       "rejects invalid uri",
+			testURI:    "not-a-uri",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled := false
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/current", nil)
+			req.Header.Set(testURIHeader, tt.testURI)
+			rec := httptest.NewRecorder()
+
+			newMiddleware(true, &nextCalled).ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.False(t, nextCalled)
+			for name, value := range tt.wantHeaders {
+				assert.Equal(t, value, rec.Header().Get(name), name)
+			}
+			if tt.wantNoHeader != "" {
+				assert.Empty(t, rec.Header().Get(tt.wantNoHeader))
+			}
+		})
+	}
+
+	t.Run("ignored when debug is off", func(t *testing.T) {
+		nextCalled := false
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/current", nil)
+		req.Header.Set(testURIHeader, "/old")
+		rec := httptest.NewRecorder()
+
+		newMiddleware(false, &nextCalled).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.True(t, nextCalled)
+		assert.Empty(t, rec.Header().Get("X-Middleware-Flecto-Test-Result"))
+	})
+}
+
+func TestServerTiming(t *testing.T) {
+	assert.Equal(t, "flecto;dur=0.000", serverTiming(0))
+	assert.Equal(t, "flecto;dur=1.500", serverTiming(1500*time.Microsecond))
+	assert.Equal(t, "flecto;dur=12.000", serverTiming(12*time.Millisecond))
+}
+
+func TestMiddleware_ServeHTTP_ServerTiming(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	newMiddleware := func(debug bool) *Middleware {
+		return &Middleware{
+			name:          "test",
+			next:          next,
+			defaultClient: &mockClient{},
+			hostClients:   map[string]client.Client{},
+			debug:         debug,
+		}
+	}
+
+	t.Run("emitted when debug is enabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware(true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+		assert.Regexp(t, regexp.MustCompile(`^flecto;dur=\d+\.\d{3}$`), rec.Header().Get("Server-Timing"))
+	})
+
+	t.Run("absent when debug is disabled", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware(false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+		assert.Empty(t, rec.Header().Get("Server-Timing"))
+	})
+}
+
+func TestMiddleware_ServeHTTP_SlowMatch(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			time.Sleep(20 * time.Millisecond)
+			return nil, ""
+		},
+	}
+	newMiddleware := func(debug bool) *Middleware {
+		return &Middleware{
+			name:               "test",
+			next:               http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			defaultClient:      mock,
+			hostClients:        map[string]client.Client{},
+			debug:              debug,
+			slowMatchThreshold: 5 * time.Millisecond,
+		}
+	}
+
+	t.Run("logged when debug is enabled", func(t *testing.T) {
+		logs := captureLogs(t)
+		newMiddleware(true).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+
+		assert.Contains(t, logs.String(), "test: Slow redirect match for example.com/path: ")
+		assert.NotContains(t, logs.String(), "Slow page match")
+	})
+
+	t.Run("not logged when debug is disabled", func(t *testing.T) {
+		logs := captureLogs(t)
+		newMiddleware(false).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+
+		assert.Empty(t, logs.String())
+	})
+}
+
+func TestMiddleware_ServeHTTP_RequestID(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri != "/old" {
+				return nil, ""
+			}
+			return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/robots.txt" {
+				return nil
+			}
+			return &types.Page{Path: "/robots.txt", Content: "User-agent: *"}
+		},
+	}
+	m := &Middleware{
+		name:          "test",
+		next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{},
+		debug:         true,
+	}
+
+	t.Run("propagates an existing id", func(t *testing.T) {
+		logs := captureLogs(t)
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)
+		req.Header.Set("X-Request-Id", "abc-123")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+
+		assert.Equal(t, "abc-123", rec.Header().Get("X-Middleware-Flecto-Request-Id"))
+		assert.Contains(t, logs.String(), "test: request abc-123: example.com/old matched redirect /old -> /new")
+	})
+
+	t.Run("generates an id when absent", func(t *testing.T) {
+		logs := captureLogs(t)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil))
+
+		id := rec.Header().Get("X-Middleware-Flecto-Request-Id")
+		assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{32}$`), id)
+		assert.Contains(t, logs.String(), "test: request "+id+": example.com/robots.txt matched page /robots.txt")
+	})
+
+	t.Run("not reported on passthrough", func(t *testing.T) {
+		logs := captureLogs(t)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/other", nil))
+
+		assert.Empty(t, rec.Header().Get("X-Middleware-Flecto-Request-Id"))
+		assert.Empty(t, logs.String())
+	})
+}
+
+func TestMiddleware_ServeHTTP_ClientHeader(t *testing.T) {
+	defaultMock := &mockClient{}
+	hostMock := &mockClient{}
+	m := &Middleware{
+		name:          "test",
+		next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient: defaultMock,
+		hostClients:   map[string]client.Client{"example.fr": hostMock},
+		states: map[client.Client]*clientState{
+			defaultMock: newClientState("http://localhost|ns|proj", defaultMock),
+			hostMock:    newClientState("http://localhost|ns|proj-fr", hostMock),
+		},
+		debug: true,
+	}
+
+	serve := func(url string) string {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec.Header().Get("X-Middleware-Flecto-Client")
+	}
+	defaultID := serve("http://example.com/path")
+	hostID := serve("http://example.fr/path")
+
+	assert.Equal(t, clientID("http://localhost|ns|proj"), defaultID)
+	assert.Equal(t, clientID("http://localhost|ns|proj-fr"), hostID)
+	assert.NotEqual(t, defaultID, hostID)
+	assert.NotContains(t, defaultID, "proj")
+
+	m.debug = false
+	assert.Empty(t, serve("http://example.com/path"))
+}
+
+func TestMiddleware_ServeHTTP_ClientErrorHeader(t *testing.T) {
+	mock := &mockClient{}
+	state := newClientState("http://localhost|ns|proj", mock)
+	m := &Middleware{
+		name:          "test",
+		next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{},
+		states:        map[client.Client]*clientState{mock: state},
+		debug:         true,
+	}
+	serve := func() string {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+		return rec.Header().Get("X-Middleware-Flecto-Client-Error")
+	}
+
+	assert.Empty(t, serve())
+
+	state.recordReload(errors.New("connection refused"))
+	state.recordReload(errors.New("unexpected status 401:\n  invalid token"))
+	assert.Equal(t, "2 failures: unexpected status 401: invalid token", serve())
+
+	state.recordReload(nil)
+	assert.Empty(t, serve())
+}
+
+func TestClientID(t *testing.T) {
+	assert.Equal(t, clientID("key"), clientID("key"))
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{1,16}$`), clientID("http://localhost|ns|proj"))
+}
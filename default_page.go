@@ -0,0 +1,35 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Paths of the default pages
+const (
+	robotsTxtPath = "/robots.txt"
+	faviconPath   = "/favicon.ico"
+)
+
+// serveDefaultPage serves the default robots.txt or empty favicon when enabled, for GET and HEAD requests.
+// It is only called when no rule matched, so pages of the manager always take precedence.
+func (m *Middleware) serveDefaultPage(rw http.ResponseWriter, req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	var contentType, content string
+	switch {
+	case req.URL.Path == robotsTxtPath && m.defaultRobotsTxt != "":
+		contentType, content = "text/plain", m.defaultRobotsTxt
+	case req.URL.Path == faviconPath && m.defaultFavicon:
+		contentType = "image/x-icon"
+	default:
+		return false
+	}
+	rw = headWriter(rw, req)
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	rw.WriteHeader(http.StatusOK)
+	_, _ = rw.Write([]byte(content))
+	return true
+}
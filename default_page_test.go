@@ -0,0 +1,92 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_DefaultPages(t *testing.T) {
+	const robots = "User-agent: *\nDisallow: /"
+
+	newMiddleware := func(pages map[string]*types.Page) (*Middleware, *bool) {
+		nextCalled := false
+		return &Middleware{
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusNotFound)
+			}),
+			defaultClient: &mockClient{
+				pageMatch: func(hostname, uri string) *types.Page {
+					return pages[uri]
+				},
+			},
+			hostClients:      map[string]client.Client{},
+			defaultRobotsTxt: robots,
+			defaultFavicon:   true,
+		}, &nextCalled
+	}
+
+	tests := []struct {
+		name            string
+		method          string
+		path            string
+		wantContentType string
+		wantBody        string
+	}{
+		{name: "robots.txt", method: http.MethodGet, path: "/robots.txt", wantContentType: "text/plain", wantBody: robots},
+		{name: "favicon", method: http.MethodGet, path: "/favicon.ico", wantContentType: "image/x-icon", wantBody: ""},
+		{name: "robots.txt head", method: http.MethodHead, path: "/robots.txt", wantContentType: "text/plain", wantBody: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, nextCalled := newMiddleware(nil)
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(tt.method, "http://example.com"+tt.path, nil))
+
+			assert.False(t, *nextCalled)
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+
+	t.Run("configured page takes precedence", func(t *testing.T) {
+		m, nextCalled := newMiddleware(map[string]*types.Page{
+			"/robots.txt": {Type: types.PageTypeBasic, Path: "/robots.txt", Content: "User-agent: *\nAllow: /"},
+		})
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil))
+
+		assert.False(t, *nextCalled)
+		assert.Equal(t, "User-agent: *\nAllow: /", rec.Body.String())
+	})
+
+	t.Run("other methods pass through", func(t *testing.T) {
+		m, nextCalled := newMiddleware(nil)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "http://example.com/robots.txt", nil))
+
+		assert.True(t, *nextCalled)
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		for _, path := range []string{"/robots.txt", "/favicon.ico"} {
+			nextCalled := false
+			m := &Middleware{
+				next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					nextCalled = true
+				}),
+				defaultClient: &mockClient{},
+				hostClients:   map[string]client.Client{},
+			}
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+			assert.True(t, nextCalled, path)
+		}
+	})
+}
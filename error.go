@@ -0,0 +1,37 @@
+package flecto_traefik_middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Formats of the error responses generated by the middleware
+const (
+	syntheticErrorFormatText = "text"
+	syntheticErrorFormatJSON = "json"
+)
+
+// syntheticError is the body of an error response generated by the middleware in the json format.
+type syntheticError struct {
+	Error  string `json:"error"`
+	Status int    `json:"status"`
+}
+
+// writeSyntheticError answers with an error generated by the middleware, in the given format.
+// The text format is the one of http.Error.
+func writeSyntheticError(rw http.ResponseWriter, format string, status int, message string) {
+	if format != syntheticErrorFormatJSON {
+		http.Error(rw, message, status)
+		return
+	}
+	body, _ := json.Marshal(syntheticError{Error: message, Status: status})
+	rw.Header().Del("Content-Length")
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+	_, _ = rw.Write(append(body, '\n'))
+}
+
+func isSyntheticErrorFormat(format string) bool {
+	return format == "" || format == syntheticErrorFormatText || format == syntheticErrorFormatJSON
+}
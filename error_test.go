@@ -0,0 +1,99 @@
+package flecto_traefik_middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteSyntheticError(t *testing.T) {
+	t.Run("text format", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeSyntheticError(rec, syntheticErrorFormatText, http.StatusServiceUnavailable, "Service Unavailable")
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get("Content-Type"))
+		assert.Equal(t, "Service Unavailable\n", rec.Body.String())
+	})
+
+	t.Run("json format", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		writeSyntheticError(rec, syntheticErrorFormatJSON, http.StatusServiceUnavailable, "Service Unavailable")
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		var body map[string]interface{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+		assert.Equal(t, map[string]interface{}{"error": "Service Unavailable", "status": float64(503)}, body)
+	})
+}
+
+func TestMiddleware_ServeHTTP_SyntheticErrorFormatJSON(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("stale rules", func(t *testing.T) {
+		mock := &mockClient{}
+		state := newClientState("key", mock)
+		state.lastSuccess = time.Now().Add(-time.Hour)
+		m := &Middleware{
+			next:                 next,
+			defaultClient:        mock,
+			hostClients:          map[string]client.Client{},
+			states:               map[client.Client]*clientState{mock: state},
+			staleAfter:           time.Minute,
+			staleStatus:          http.StatusServiceUnavailable,
+			syntheticErrorFormat: syntheticErrorFormatJSON,
+		}
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"error":"Service Unavailable","status":503}`, rec.Body.String())
+	})
+
+	t.Run("maintenance without page", func(t *testing.T) {
+		policy, err := newHostPolicy(HostConfig{MaintenanceMode: true})
+		assert.NoError(t, err)
+		m := &Middleware{
+			next:                 next,
+			hostClients:          map[string]client.Client{},
+			hostPolicies:         map[string]*hostPolicy{"example.com": policy},
+			syntheticErrorFormat: syntheticErrorFormatJSON,
+		}
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Equal(t, "300", rec.Header().Get("Retry-After"))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.JSONEq(t, `{"error":"Service Unavailable","status":503}`, rec.Body.String())
+	})
+
+	t.Run("invalid test uri", func(t *testing.T) {
+		m := &Middleware{
+			next:                 next,
+			defaultClient:        &mockClient{},
+			hostClients:          map[string]client.Client{},
+			debug:                true,
+			syntheticErrorFormat: syntheticErrorFormatJSON,
+		}
+
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/path", nil)
+		req.Header.Set(testURIHeader, "not a uri")
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.JSONEq(t, `{"error":"invalid X-Flecto-Test-Uri header","status":400}`, rec.Body.String())
+	})
+}
@@ -0,0 +1,19 @@
+package flecto_traefik_middleware
+
+// MatchEvent describes the decision taken by the middleware for a request.
+type MatchEvent struct {
+	Host string
+	URI  string
+	// Decision is one of the access log decisions: redirect, page, passthrough, ...
+	Decision string
+	// Status is the status written by the middleware, 0 when the request was passed to the next handler
+	Status int
+}
+
+// publishEvent sends an event without blocking, it is dropped when the channel is full.
+func (m *Middleware) publishEvent(event MatchEvent) {
+	select {
+	case m.events <- event:
+	default:
+	}
+}
@@ -0,0 +1,69 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_Events(t *testing.T) {
+	newMiddleware := func(events chan MatchEvent) *Middleware {
+		return &Middleware{
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			defaultClient: &mockClient{
+				redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+					if uri != "/old" {
+						return nil, ""
+					}
+					return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+				},
+				pageMatch: func(hostname, uri string) *types.Page {
+					if uri != "/robots.txt" {
+						return nil
+					}
+					return &types.Page{Type: types.PageTypeBasic, Path: "/robots.txt", Content: "User-agent: *"}
+				},
+			},
+			hostClients: map[string]client.Client{},
+			events:      events,
+		}
+	}
+
+	t.Run("publishes each decision", func(t *testing.T) {
+		events := make(chan MatchEvent, 3)
+		m := newMiddleware(events)
+
+		for _, path := range []string{"/old", "/robots.txt", "/other?q=1"} {
+			m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+		}
+
+		assert.Equal(t, MatchEvent{Host: "example.com", URI: "/old", Decision: decisionRedirect, Status: http.StatusMovedPermanently}, <-events)
+		assert.Equal(t, MatchEvent{Host: "example.com", URI: "/robots.txt", Decision: decisionPage, Status: http.StatusOK}, <-events)
+		assert.Equal(t, MatchEvent{Host: "example.com", URI: "/other?q=1", Decision: decisionPassthrough}, <-events)
+	})
+
+	t.Run("drops events when the channel is full", func(t *testing.T) {
+		events := make(chan MatchEvent, 1)
+		m := newMiddleware(events)
+
+		m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Len(t, events, 1)
+		assert.Equal(t, "/old", (<-events).URI)
+	})
+
+	t.Run("unbuffered channel without receiver never blocks", func(t *testing.T) {
+		m := newMiddleware(make(chan MatchEvent))
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	})
+}
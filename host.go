@@ -0,0 +1,169 @@
+package flecto_traefik_middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+)
+
+// defaultMaintenanceRetryAfter is sent in the Retry-After header when maintenance_retry_after is not set
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// hostPolicy holds the request handling options of a HostConfig, applied before rule matching.
+type hostPolicy struct {
+	maintenance           bool
+	maintenancePage       string
+	maintenanceRetryAfter string
+	exemptPaths           []string
+	forceHTTPS            bool
+	// extraPages overlays the pages of the shared client, nil when the host config has none
+	extraPages types.PageTreeMatcher
+	// pageRewrites maps request paths to the path whose page is served for them
+	pageRewrites map[string]string
+	// responseHeaders are added to the responses of the hosts, nil when the host config has none
+	responseHeaders http.Header
+
+	// migrationClient serves migrationWeight percent of the clients during a project migration
+	migrationClient client.Client
+	migrationWeight uint32
+	// rolloutHash is the rollout_hash bucketing the clients, fnv when empty
+	rolloutHash string
+}
+
+func newHostPolicy(hc HostConfig) (*hostPolicy, error) {
+	retryAfter, err := parseOptionalDuration("maintenance_retry_after", hc.MaintenanceRetryAfter)
+	if err != nil {
+		return nil, err
+	}
+	if retryAfter == 0 {
+		retryAfter = defaultMaintenanceRetryAfter
+	}
+	var extraPages types.PageTreeMatcher
+	if len(hc.ExtraPages) > 0 {
+		extraPages = types.NewPageTreeMatcher()
+		for i := range hc.ExtraPages {
+			page := hc.ExtraPages[i]
+			if page.Type == "" {
+				page.Type = types.PageTypeBasic
+			}
+			extraPages.Insert(&page)
+		}
+	}
+	var responseHeaders http.Header
+	if len(hc.ResponseHeaders) > 0 {
+		responseHeaders = make(http.Header, len(hc.ResponseHeaders))
+		for name, value := range hc.ResponseHeaders {
+			responseHeaders.Set(name, value)
+		}
+	}
+	return &hostPolicy{
+		maintenance:           hc.MaintenanceMode,
+		maintenancePage:       hc.MaintenancePage,
+		maintenanceRetryAfter: strconv.Itoa(int(retryAfter.Seconds())),
+		exemptPaths:           hc.ExemptPaths,
+		forceHTTPS:            hc.ForceHTTPS,
+		extraPages:            extraPages,
+		pageRewrites:          hc.PageRewrites,
+		responseHeaders:       responseHeaders,
+		migrationWeight:       uint32(hc.MigrateWeight),
+	}, nil
+}
+
+// serveMaintenance answers with the maintenance page, errorFormat applies when the page is empty.
+func (p *hostPolicy) serveMaintenance(rw http.ResponseWriter, errorFormat string) {
+	rw.Header().Set("Retry-After", p.maintenanceRetryAfter)
+	body := p.maintenancePage
+	if body == "" {
+		if errorFormat == syntheticErrorFormatJSON {
+			writeSyntheticError(rw, errorFormat, http.StatusServiceUnavailable, http.StatusText(http.StatusServiceUnavailable))
+			return
+		}
+		body = http.StatusText(http.StatusServiceUnavailable)
+	}
+	rw.Header().Set("Content-Type", http.DetectContentType([]byte(body)))
+	rw.WriteHeader(http.StatusServiceUnavailable)
+	_, _ = rw.Write([]byte(body))
+}
+
+// matchPage matches the extra pages of the host config first, then the pages of c.
+// The path of uri is rewritten first when the host config has a page rewrite for it.
+func matchPage(c client.Client, policy *hostPolicy, host, uri string) *types.Page {
+	if policy != nil && len(policy.pageRewrites) > 0 {
+		uri = policy.rewritePage(uri)
+	}
+	if policy != nil && policy.extraPages != nil {
+		if page := policy.extraPages.Match(host, uri); page != nil {
+			return page
+		}
+	}
+	return c.PageMatch(host, uri)
+}
+
+// rewritePage replaces the path of uri by its page rewrite target, keeping the query.
+func (p *hostPolicy) rewritePage(uri string) string {
+	path, query, hasQuery := strings.Cut(uri, "?")
+	target, ok := p.pageRewrites[path]
+	if !ok {
+		return uri
+	}
+	if hasQuery {
+		return target + "?" + query
+	}
+	return target
+}
+
+// policyForHost returns the policy of the host config serving host, nil when there is none.
+func (m *Middleware) policyForHost(host string) *hostPolicy {
+	m.hostsMu.RLock()
+	defer m.hostsMu.RUnlock()
+	return m.hostPolicies[hostname(host)]
+}
+
+// validateHostPolicy validates the request handling options of a host config.
+func validateHostPolicy(i int, hc HostConfig) error {
+	if err := checkHostPolicy(hc); err != nil {
+		return fmt.Errorf("host_configs[%d]: %w", i, err)
+	}
+	return nil
+}
+
+// checkHostPolicy validates the request handling options of a host config, errors are not prefixed by its index.
+func checkHostPolicy(hc HostConfig) error {
+	if _, err := parseOptionalDuration("maintenance_retry_after", hc.MaintenanceRetryAfter); err != nil {
+		return err
+	}
+	if hc.MigrateWeight < 0 || hc.MigrateWeight > 100 {
+		return fmt.Errorf("migrate_weight must be between 0 and 100")
+	}
+	if hc.MigrateTo != nil && hc.MigrateTo.ProjectCode == "" {
+		return fmt.Errorf("migrate_to: project_code is required")
+	}
+	// Rewrites are applied once, a target rewritten again would chain or loop
+	for source, target := range hc.PageRewrites {
+		if !strings.HasPrefix(source, "/") || !strings.HasPrefix(target, "/") {
+			return fmt.Errorf("page_rewrites: %q -> %q: paths must start with /", source, target)
+		}
+		if _, chained := hc.PageRewrites[target]; chained {
+			return fmt.Errorf("page_rewrites: %q -> %q: target is rewritten again", source, target)
+		}
+	}
+	for name, value := range hc.ResponseHeaders {
+		if !isHTTPToken(name) || strings.ContainsAny(value, "\r\n") {
+			return fmt.Errorf("response_headers %q is not a valid header", name)
+		}
+	}
+	for j, page := range hc.ExtraPages {
+		if page.Path == "" {
+			return fmt.Errorf("extra_pages[%d]: path is required", j)
+		}
+		if page.Type != "" && page.Type != types.PageTypeBasic && page.Type != types.PageTypeBasicHost {
+			return fmt.Errorf("extra_pages[%d]: unknown type %q", j, page.Type)
+		}
+	}
+	return nil
+}
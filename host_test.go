@@ -0,0 +1,264 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_Maintenance(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: uri,
+				Target: "/redirected",
+				Status: types.RedirectStatusFound,
+			}, "/redirected"
+		},
+	}
+	policy, err := newHostPolicy(HostConfig{
+		MaintenanceMode:       true,
+		MaintenancePage:       "<html><body>Back soon</body></html>",
+		MaintenanceRetryAfter: "2m",
+		ExemptPaths:           []string{"/healthz", "/status/*"},
+	})
+	assert.NoError(t, err)
+
+	nextCalled := false
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nextCalled = true
+			w.WriteHeader(http.StatusOK)
+		}),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{"example.com": mock},
+		hostPolicies:  map[string]*hostPolicy{"example.com": policy},
+	}
+
+	t.Run("serves maintenance page for every path", func(t *testing.T) {
+		for _, path := range []string{"/", "/old-path", "/healthz/deep"} {
+			nextCalled = false
+			req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+			rec := httptest.NewRecorder()
+
+			m.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+			assert.Equal(t, "120", rec.Header().Get("Retry-After"))
+			assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+			assert.Equal(t, "<html><body>Back soon</body></html>", rec.Body.String())
+			assert.False(t, nextCalled)
+		}
+	})
+
+	t.Run("exempt paths pass through without rule matching", func(t *testing.T) {
+		for _, path := range []string{"/healthz", "/status/live"} {
+			nextCalled = false
+			req := httptest.NewRequest(http.MethodGet, "http://example.com:8443"+path, nil)
+			rec := httptest.NewRecorder()
+
+			m.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.True(t, nextCalled)
+		}
+	})
+
+	t.Run("other hosts are not in maintenance", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://other.com/old-path", nil)
+		rec := httptest.NewRecorder()
+
+		m.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusFound, rec.Code)
+	})
+}
+
+func TestHostPolicy_ServeMaintenance_Defaults(t *testing.T) {
+	policy, err := newHostPolicy(HostConfig{MaintenanceMode: true})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	policy.serveMaintenance(rec, syntheticErrorFormatText)
+
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Equal(t, "300", rec.Header().Get("Retry-After"))
+	assert.Equal(t, "Service Unavailable", rec.Body.String())
+}
+
+func TestNew_MaintenanceHostConfig(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+	clientFactory = func(cfg *client.Config) client.Client {
+		return &mockClient{}
+	}
+
+	config := &Config{
+		ClientSettings: ClientSettings{
+			ManagerUrl:    "http://localhost:8080",
+			NamespaceCode: "ns",
+			TokenJWT:      "token",
+		},
+		HostConfigs: []HostConfig{
+			{
+				Hosts:           []string{"example.com", "example.fr"},
+				ClientSettings:  ClientSettings{ProjectCode: "proj"},
+				MaintenanceMode: true,
+			},
+		},
+	}
+
+	t.Run("policies are bound to every host", func(t *testing.T) {
+		handler, err := New(context.Background(), http.NotFoundHandler(), config, "test-middleware")
+		assert.NoError(t, err)
+
+		m := handler.(*Middleware)
+		assert.True(t, m.hostPolicies["example.com"].maintenance)
+		assert.Same(t, m.hostPolicies["example.com"], m.hostPolicies["example.fr"])
+	})
+
+	t.Run("invalid retry after is rejected", func(t *testing.T) {
+		config.HostConfigs[0].MaintenanceRetryAfter = "soon"
+		_, err := New(context.Background(), http.NotFoundHandler(), config, "test-middleware")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "host_configs[0]: invalid maintenance_retry_after duration")
+	})
+}
+
+func TestMiddleware_ServeHTTP_ExtraPages(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+	shared := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/robots.txt" {
+				return nil
+			}
+			return &types.Page{Type: types.PageTypeBasic, Path: "/robots.txt", Content: "shared"}
+		},
+	}
+	clientFactory = func(cfg *client.Config) client.Client {
+		return shared
+	}
+
+	config := &Config{
+		ClientSettings: ClientSettings{
+			ManagerUrl:    "http://localhost:8080",
+			NamespaceCode: "ns",
+			TokenJWT:      "token",
+		},
+		HostConfigs: []HostConfig{
+			{
+				Hosts:          []string{"example.com"},
+				ClientSettings: ClientSettings{ProjectCode: "proj"},
+				ExtraPages: []types.Page{
+					{Path: "/robots.txt", Content: "overlay"},
+					{Type: types.PageTypeBasicHost, Path: "example.com/humans.txt", Content: "humans"},
+				},
+			},
+			{
+				Hosts:          []string{"example.fr"},
+				ClientSettings: ClientSettings{ProjectCode: "proj"},
+			},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := New(ctx, http.NotFoundHandler(), config, "test-extra-pages")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		requestURL string
+		wantCode   int
+		wantBody   string
+	}{
+		{name: "overlay wins for its host", requestURL: "http://example.com/robots.txt", wantCode: http.StatusOK, wantBody: "overlay"},
+		{name: "host overlay page", requestURL: "http://example.com/humans.txt", wantCode: http.StatusOK, wantBody: "humans"},
+		{name: "other host keeps shared page", requestURL: "http://example.fr/robots.txt", wantCode: http.StatusOK, wantBody: "shared"},
+		{name: "other host does not see overlay", requestURL: "http://example.fr/humans.txt", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.requestURL, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateHostPolicy_ExtraPages(t *testing.T) {
+	err := validateHostPolicy(1, HostConfig{ExtraPages: []types.Page{{Content: "no path"}}})
+	assert.EqualError(t, err, "host_configs[1]: extra_pages[0]: path is required")
+
+	err = validateHostPolicy(0, HostConfig{ExtraPages: []types.Page{{Type: "REGEX", Path: "/a"}}})
+	assert.EqualError(t, err, `host_configs[0]: extra_pages[0]: unknown type "REGEX"`)
+
+	assert.NoError(t, validateHostPolicy(0, HostConfig{ExtraPages: []types.Page{{Path: "/a"}}}))
+}
+
+func TestMiddleware_ServeHTTP_PageRewrites(t *testing.T) {
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/canonical" && uri != "/canonical?lang=fr" {
+				return nil
+			}
+			return &types.Page{Type: types.PageTypeBasic, Path: "/canonical", Content: "canonical content"}
+		},
+	}
+	m := &Middleware{
+		next:          http.NotFoundHandler(),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{},
+		hostPolicies: map[string]*hostPolicy{
+			"example.com": {pageRewrites: map[string]string{"/new-path": "/canonical"}},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		requestURL string
+		wantCode   int
+		wantBody   string
+	}{
+		{name: "rewritten path serves the target page", requestURL: "http://example.com/new-path", wantCode: http.StatusOK, wantBody: "canonical content"},
+		{name: "query is kept", requestURL: "http://example.com/new-path?lang=fr", wantCode: http.StatusOK, wantBody: "canonical content"},
+		{name: "target path is still served", requestURL: "http://example.com/canonical", wantCode: http.StatusOK, wantBody: "canonical content"},
+		{name: "other host is not rewritten", requestURL: "http://example.fr/new-path", wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.requestURL, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Empty(t, rec.Header().Get("Location"))
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidateHostPolicy_PageRewrites(t *testing.T) {
+	err := validateHostPolicy(0, HostConfig{PageRewrites: map[string]string{"/a": "/a"}})
+	assert.EqualError(t, err, `host_configs[0]: page_rewrites: "/a" -> "/a": target is rewritten again`)
+
+	err = validateHostPolicy(0, HostConfig{PageRewrites: map[string]string{"/a": "/b", "/b": "/a"}})
+	assert.ErrorContains(t, err, "target is rewritten again")
+
+	err = validateHostPolicy(2, HostConfig{PageRewrites: map[string]string{"a": "/b"}})
+	assert.EqualError(t, err, `host_configs[2]: page_rewrites: "a" -> "/b": paths must start with /`)
+
+	assert.NoError(t, validateHostPolicy(0, HostConfig{PageRewrites: map[string]string{"/a": "/c", "/b": "/c"}}))
+}
@@ -0,0 +1,111 @@
+package flecto_traefik_middleware
+
+import (
+	"fmt"
+
+	"github.com/flectolab/go-client"
+)
+
+// UpdateHostConfig applies hc to its hosts as New would, without recreating the clients of the other hosts.
+// The client of hc is reused when one with the same settings already exists, and the clients left without
+// any host are stopped. With shared_clients, they are kept until the middleware is replaced.
+func (m *Middleware) UpdateHostConfig(hc HostConfig) error {
+	if len(hc.Hosts) == 0 {
+		return fmt.Errorf("%s: hosts is required and cannot be empty", m.name)
+	}
+	if hc.ProjectCode == "" {
+		return fmt.Errorf("%s: project_code is required", m.name)
+	}
+	if hc.Default {
+		return fmt.Errorf("%s: default can only be set in the configuration of New", m.name)
+	}
+	if err := checkHostPolicy(hc); err != nil {
+		return fmt.Errorf("%s: %w", m.name, err)
+	}
+	settings := mergeSettings(m.baseSettings, hc.ClientSettings)
+	if m.minIntervalCheck > 0 {
+		if err := checkMinIntervalCheck(settings, m.minIntervalCheck); err != nil {
+			return fmt.Errorf("%s: %w", m.name, err)
+		}
+		if hc.MigrateTo != nil {
+			if err := checkMinIntervalCheck(mergeSettings(m.baseSettings, *hc.MigrateTo), m.minIntervalCheck); err != nil {
+				return fmt.Errorf("%s: migrate_to: %w", m.name, err)
+			}
+		}
+	}
+
+	m.updateMu.Lock()
+	defer m.updateMu.Unlock()
+
+	hostClient, err := m.reusedClient(settings)
+	if err != nil {
+		return err
+	}
+	policy, err := newHostPolicy(hc)
+	if err != nil {
+		return fmt.Errorf("%s: %w", m.name, err)
+	}
+	policy.rolloutHash = m.rolloutHash
+	if hc.MigrateTo != nil {
+		policy.migrationClient, err = m.reusedClient(mergeSettings(m.baseSettings, *hc.MigrateTo))
+		if err != nil {
+			return err
+		}
+	}
+
+	m.hostsMu.Lock()
+	for _, host := range hc.Hosts {
+		m.hostClients[host] = hostClient
+		m.hostPolicies[host] = policy
+	}
+	var unused []*clientState
+	if !m.sharedClients {
+		unused = m.unusedStates()
+		for _, state := range unused {
+			delete(m.states, state.client)
+		}
+	}
+	m.hostsMu.Unlock()
+
+	for _, state := range unused {
+		state.stop()
+		if m.debug {
+			m.logf("Stopped client %s, it no longer serves any host", state.redactedKey)
+		}
+	}
+	return nil
+}
+
+// reusedClient returns the client of this middleware with the settings key of settings, creating it when there is none.
+func (m *Middleware) reusedClient(settings ClientSettings) (client.Client, error) {
+	key := settingsKey(settings)
+	m.hostsMu.RLock()
+	for c, state := range m.states {
+		if state.key == key {
+			m.hostsMu.RUnlock()
+			return c, nil
+		}
+	}
+	m.hostsMu.RUnlock()
+	return m.createClient(settings)
+}
+
+// unusedStates returns the states of the clients serving no host, hostsMu must be held.
+func (m *Middleware) unusedStates() []*clientState {
+	used := map[client.Client]bool{m.defaultClient: true, m.secondaryDefaultClient: true}
+	for _, c := range m.hostClients {
+		used[c] = true
+	}
+	for _, policy := range m.hostPolicies {
+		if policy.migrationClient != nil {
+			used[policy.migrationClient] = true
+		}
+	}
+	var unused []*clientState
+	for c, state := range m.states {
+		if !used[c] {
+			unused = append(unused, state)
+		}
+	}
+	return unused
+}
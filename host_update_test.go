@@ -0,0 +1,167 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_UpdateHostConfig(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+
+	var created []string
+	clientFactory = func(cfg *client.Config) client.Client {
+		project := cfg.ProjectCode
+		created = append(created, project)
+		return &mockClient{
+			redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+				return &types.Redirect{Source: uri, Target: "/" + project, Status: types.RedirectStatusFound}, "/" + project
+			},
+		}
+	}
+
+	newMiddleware := func(t *testing.T) *Middleware {
+		t.Helper()
+		created = nil
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		handler, err := New(ctx, http.NotFoundHandler(), &Config{
+			ClientSettings: ClientSettings{
+				ManagerUrl:    "http://localhost:8080",
+				NamespaceCode: "ns",
+				ProjectCode:   "default-proj",
+				TokenJWT:      "token",
+			},
+			HostConfigs: []HostConfig{
+				{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: "proj-fr"}},
+				{Hosts: []string{"example.es"}, ClientSettings: ClientSettings{ProjectCode: "proj-es"}},
+			},
+		}, "test-update")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"default-proj", "proj-fr", "proj-es"}, created)
+		created = nil
+		return handler.(*Middleware)
+	}
+	location := func(m *Middleware, host string) string {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://"+host+"/old", nil))
+		return rec.Header().Get("Location")
+	}
+
+	t.Run("only the updated client is recreated", func(t *testing.T) {
+		m := newMiddleware(t)
+		defaultClient, esClient, frClient := m.defaultClient, m.hostClients["example.es"], m.hostClients["example.fr"]
+
+		err := m.UpdateHostConfig(HostConfig{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: "proj-fr-v2"}})
+
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"proj-fr-v2"}, created)
+		assert.Same(t, defaultClient, m.defaultClient)
+		assert.Same(t, esClient, m.hostClients["example.es"])
+		assert.NotSame(t, frClient, m.hostClients["example.fr"])
+		assert.Equal(t, "/proj-fr-v2", location(m, "example.fr"))
+		assert.Equal(t, "/proj-es", location(m, "example.es"))
+		assert.Equal(t, "/default-proj", location(m, "other.com"))
+
+		// The previous client serves no host anymore
+		assert.Nil(t, m.clientState(frClient))
+		assert.Len(t, m.Stats(), 3)
+	})
+
+	t.Run("existing client is reused", func(t *testing.T) {
+		m := newMiddleware(t)
+
+		err := m.UpdateHostConfig(HostConfig{Hosts: []string{"example.be"}, ClientSettings: ClientSettings{ProjectCode: "proj-es"}})
+
+		assert.NoError(t, err)
+		assert.Empty(t, created)
+		assert.Same(t, m.hostClients["example.es"], m.hostClients["example.be"])
+		assert.Equal(t, "/proj-es", location(m, "example.be"))
+	})
+
+	t.Run("host policy is replaced", func(t *testing.T) {
+		m := newMiddleware(t)
+
+		err := m.UpdateHostConfig(HostConfig{Hosts: []string{"example.es"}, ClientSettings: ClientSettings{ProjectCode: "proj-es"}, MaintenanceMode: true})
+
+		assert.NoError(t, err)
+		assert.Empty(t, created)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.es/old", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	})
+
+	t.Run("invalid host config", func(t *testing.T) {
+		m := newMiddleware(t)
+		tests := []struct {
+			hc      HostConfig
+			wantErr string
+		}{
+			{hc: HostConfig{ClientSettings: ClientSettings{ProjectCode: "proj"}}, wantErr: "test-update: hosts is required and cannot be empty"},
+			{hc: HostConfig{Hosts: []string{"example.fr"}}, wantErr: "test-update: project_code is required"},
+			{hc: HostConfig{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: "proj"}, Default: true}, wantErr: "test-update: default can only be set in the configuration of New"},
+			{hc: HostConfig{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: "proj"}, MigrateWeight: 101}, wantErr: "test-update: migrate_weight must be between 0 and 100"},
+		}
+		for _, tt := range tests {
+			assert.EqualError(t, m.UpdateHostConfig(tt.hc), tt.wantErr)
+		}
+		assert.Empty(t, created)
+		assert.Equal(t, "/proj-fr", location(m, "example.fr"))
+	})
+
+	t.Run("min_interval_check", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		handler, err := New(ctx, http.NotFoundHandler(), &Config{
+			ClientSettings: ClientSettings{
+				ManagerUrl:    "http://localhost:8080",
+				NamespaceCode: "ns",
+				ProjectCode:   "default-proj",
+				TokenJWT:      "token",
+				IntervalCheck: "1m",
+			},
+			MinIntervalCheck: "30s",
+		}, "test-update-min-interval")
+		assert.NoError(t, err)
+		m := handler.(*Middleware)
+		created = nil
+
+		err = m.UpdateHostConfig(HostConfig{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: "proj-fr", IntervalCheck: "5s"}})
+		assert.EqualError(t, err, "test-update-min-interval: interval_check 5s is below min_interval_check 30s")
+		err = m.UpdateHostConfig(HostConfig{
+			Hosts:          []string{"example.fr"},
+			ClientSettings: ClientSettings{ProjectCode: "proj-fr"},
+			MigrateTo:      &ClientSettings{ProjectCode: "proj-next", IntervalCheck: "5s"},
+		})
+		assert.EqualError(t, err, "test-update-min-interval: migrate_to: interval_check 5s is below min_interval_check 30s")
+		assert.Empty(t, created)
+
+		// The inherited interval is allowed
+		assert.NoError(t, m.UpdateHostConfig(HostConfig{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: "proj-fr"}}))
+		assert.Equal(t, []string{"proj-fr"}, created)
+	})
+
+	t.Run("concurrent with requests", func(t *testing.T) {
+		m := newMiddleware(t)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				location(m, "example.fr")
+			}
+		}()
+		for _, project := range []string{"proj-a", "proj-b", "proj-fr"} {
+			assert.NoError(t, m.UpdateHostConfig(HostConfig{Hosts: []string{"example.fr"}, ClientSettings: ClientSettings{ProjectCode: project}}))
+		}
+		wg.Wait()
+		assert.Equal(t, "/proj-fr", location(m, "example.fr"))
+	})
+}
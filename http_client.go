@@ -0,0 +1,117 @@
+package flecto_traefik_middleware
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flectolab/go-client"
+)
+
+// failoverClient sends manager requests to the fallback managers, in order,
+// when the primary manager is unreachable or answers with a 5xx status.
+// Every request tries the primary first, there is no stickiness to a fallback.
+type failoverClient struct {
+	next client.HTTPClient
+	// urls holds the primary manager URL followed by the fallbacks
+	urls []string
+}
+
+func (f *failoverClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := f.next.Do(req)
+	if !shouldFailover(resp, err) {
+		return resp, err
+	}
+
+	rawURL := req.URL.String()
+	if !strings.HasPrefix(rawURL, f.urls[0]) {
+		return resp, err
+	}
+	path := strings.TrimPrefix(rawURL, f.urls[0])
+
+	for _, fallback := range f.urls[1:] {
+		fallbackReq, errReq := cloneRequest(req, fallback+path)
+		if errReq != nil {
+			break
+		}
+		closeResponse(resp)
+		resp, err = f.next.Do(fallbackReq)
+		if !shouldFailover(resp, err) {
+			return resp, err
+		}
+	}
+	return resp, err
+}
+
+// tokenRotationClient retries manager requests rejected as unauthorized with the next JWT token,
+// so the manager token can be rotated without failing reloads.
+type tokenRotationClient struct {
+	next       client.HTTPClient
+	headerName string
+	nextToken  string
+}
+
+func (t *tokenRotationClient) Do(req *http.Request) (*http.Response, error) {
+	resp, err := t.next.Do(req)
+	if err != nil || (resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden) {
+		return resp, err
+	}
+
+	retryReq, errReq := cloneRequest(req, req.URL.String())
+	if errReq != nil {
+		return resp, err
+	}
+	retryReq.Header.Set(t.headerName, fmt.Sprintf("Bearer %s", t.nextToken))
+	closeResponse(resp)
+	return t.next.Do(retryReq)
+}
+
+// headerClient adds static headers to every manager request, e.g. the region or environment of the agent.
+type headerClient struct {
+	next    client.HTTPClient
+	headers http.Header
+}
+
+func (h *headerClient) Do(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, values := range h.headers {
+		req.Header[name] = values
+	}
+	return h.next.Do(req)
+}
+
+func shouldFailover(resp *http.Response, err error) bool {
+	return err != nil || resp.StatusCode >= http.StatusInternalServerError
+}
+
+func closeResponse(resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+}
+
+// cloneRequest copies req targeting rawURL, rewinding the body when there is one.
+func cloneRequest(req *http.Request, rawURL string) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	clone := req.Clone(req.Context())
+	clone.URL = u
+	clone.Host = u.Host
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, http.ErrBodyReadAfterClose
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = body
+	}
+	return clone, nil
+}
@@ -0,0 +1,173 @@
+package flecto_traefik_middleware
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailoverClient_Do(t *testing.T) {
+	newServer := func(status int, body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reqBody, _ := io.ReadAll(r.Body)
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(body + r.URL.Path + string(reqBody)))
+		}))
+	}
+
+	t.Run("uses primary when available", func(t *testing.T) {
+		primary := newServer(http.StatusOK, "primary")
+		defer primary.Close()
+		fallback := newServer(http.StatusOK, "fallback")
+		defer fallback.Close()
+
+		c := &failoverClient{next: http.DefaultClient, urls: []string{primary.URL, fallback.URL}}
+		req, _ := http.NewRequest(http.MethodGet, primary.URL+"/api/version", nil)
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "primary/api/version", string(body))
+	})
+
+	t.Run("fails over when primary is unreachable", func(t *testing.T) {
+		primary := newServer(http.StatusOK, "primary")
+		primary.Close()
+		fallback := newServer(http.StatusOK, "fallback")
+		defer fallback.Close()
+
+		c := &failoverClient{next: http.DefaultClient, urls: []string{primary.URL, fallback.URL}}
+		req, _ := http.NewRequest(http.MethodGet, primary.URL+"/api/version", nil)
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "fallback/api/version", string(body))
+	})
+
+	t.Run("fails over on server error and replays body", func(t *testing.T) {
+		primary := newServer(http.StatusBadGateway, "primary")
+		defer primary.Close()
+		second := newServer(http.StatusServiceUnavailable, "second")
+		defer second.Close()
+		third := newServer(http.StatusOK, "third")
+		defer third.Close()
+
+		c := &failoverClient{next: http.DefaultClient, urls: []string{primary.URL, second.URL, third.URL}}
+		req, _ := http.NewRequest(http.MethodPost, primary.URL+"/api/agents", bytes.NewReader([]byte(`{"name":"agent"}`)))
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, `third/api/agents{"name":"agent"}`, string(body))
+	})
+
+	t.Run("does not fail over on client error", func(t *testing.T) {
+		primary := newServer(http.StatusUnauthorized, "primary")
+		defer primary.Close()
+		fallback := newServer(http.StatusOK, "fallback")
+		defer fallback.Close()
+
+		c := &failoverClient{next: http.DefaultClient, urls: []string{primary.URL, fallback.URL}}
+		req, _ := http.NewRequest(http.MethodGet, primary.URL+"/api/version", nil)
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+
+	t.Run("returns last error when all managers fail", func(t *testing.T) {
+		primary := newServer(http.StatusInternalServerError, "primary")
+		defer primary.Close()
+		fallback := newServer(http.StatusInternalServerError, "fallback")
+		defer fallback.Close()
+
+		c := &failoverClient{next: http.DefaultClient, urls: []string{primary.URL, fallback.URL}}
+		req, _ := http.NewRequest(http.MethodGet, primary.URL+"/api/version", nil)
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "fallback/api/version", string(body))
+	})
+}
+
+func TestTokenRotationClient_Do(t *testing.T) {
+	// Manager only accepting the token passed in the X-Auth header
+	newManager := func(acceptedToken string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("X-Auth") != "Bearer "+acceptedToken {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			_, _ = w.Write(body)
+		}))
+	}
+
+	t.Run("uses current token when accepted", func(t *testing.T) {
+		manager := newManager("current")
+		defer manager.Close()
+
+		c := &tokenRotationClient{next: http.DefaultClient, headerName: "X-Auth", nextToken: "next"}
+		req, _ := http.NewRequest(http.MethodGet, manager.URL+"/api/version", nil)
+		req.Header.Add("X-Auth", "Bearer current")
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("retries with next token when current is rejected", func(t *testing.T) {
+		manager := newManager("next")
+		defer manager.Close()
+
+		c := &tokenRotationClient{next: http.DefaultClient, headerName: "X-Auth", nextToken: "next"}
+		req, _ := http.NewRequest(http.MethodPost, manager.URL+"/api/agents", bytes.NewReader([]byte("agent")))
+		req.Header.Add("X-Auth", "Bearer current")
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		body, _ := io.ReadAll(resp.Body)
+		assert.Equal(t, "agent", string(body))
+	})
+
+	t.Run("returns unauthorized when both tokens are rejected", func(t *testing.T) {
+		manager := newManager("other")
+		defer manager.Close()
+
+		c := &tokenRotationClient{next: http.DefaultClient, headerName: "X-Auth", nextToken: "next"}
+		req, _ := http.NewRequest(http.MethodGet, manager.URL+"/api/version", nil)
+		req.Header.Add("X-Auth", "Bearer current")
+		resp, err := c.Do(req)
+
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	})
+}
+
+func TestHeaderClient_Do(t *testing.T) {
+	var received http.Header
+	manager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Clone()
+	}))
+	defer manager.Close()
+
+	c := &headerClient{next: http.DefaultClient, headers: http.Header{"X-Region": {"eu-west"}}}
+	req, _ := http.NewRequest(http.MethodGet, manager.URL+"/api/version", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	resp, err := c.Do(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "eu-west", received.Get("X-Region"))
+	assert.Equal(t, "Bearer token", received.Get("Authorization"))
+	assert.Empty(t, req.Header.Get("X-Region"), "the request of the caller is not modified")
+}
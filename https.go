@@ -0,0 +1,44 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// acmeChallengePath is never upgraded by force_https, certificates must be obtainable over plain HTTP
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// isHTTPS reports whether the client reached the proxy over HTTPS.
+// X-Forwarded-Proto is only considered with trust_forwarded_headers.
+func (m *Middleware) isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	if !m.trustForwardedHeaders {
+		return false
+	}
+	proto := req.Header.Get("X-Forwarded-Proto")
+	if i := strings.IndexByte(proto, ','); i != -1 {
+		proto = proto[:i]
+	}
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
+
+// scheme returns the scheme used by the client to reach the proxy.
+func (m *Middleware) scheme(req *http.Request) string {
+	if m.isHTTPS(req) {
+		return "https"
+	}
+	return "http"
+}
+
+// shouldForceHTTPS reports whether req must be upgraded to HTTPS before rule matching.
+func (m *Middleware) shouldForceHTTPS(req *http.Request, policy *hostPolicy) bool {
+	if !m.forceHTTPS && (policy == nil || !policy.forceHTTPS) {
+		return false
+	}
+	if strings.HasPrefix(req.URL.Path, acmeChallengePath) || matchPath(m.forceHTTPSExemptPaths, req.URL.Path) {
+		return false
+	}
+	return !m.isHTTPS(req)
+}
@@ -0,0 +1,105 @@
+package flecto_traefik_middleware
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_ForceHTTPS(t *testing.T) {
+	tests := []struct {
+		name           string
+		forceHTTPS     bool
+		hostForceHTTPS bool
+		trustForwarded bool
+		requestURL     string
+		tls            bool
+		forwardedProto string
+		wantLocation   string
+	}{
+		{
+			name:         "http upgraded to https",
+			forceHTTPS:   true,
+			requestURL:   "http://example.com:80/path?a=1",
+			wantLocation: "https://example.com/path?a=1",
+		},
+		{
+			name:           "http upgraded for host config",
+			hostForceHTTPS: true,
+			requestURL:     "http://example.com/path",
+			wantLocation:   "https://example.com/path",
+		},
+		{
+			name:       "host without force_https",
+			requestURL: "http://example.com/path",
+		},
+		{
+			name:       "tls request",
+			forceHTTPS: true,
+			requestURL: "https://example.com/path",
+			tls:        true,
+		},
+		{
+			name:       "acme challenge exempt",
+			forceHTTPS: true,
+			requestURL: "http://example.com/.well-known/acme-challenge/token",
+		},
+		{
+			name:       "configured exempt path",
+			forceHTTPS: true,
+			requestURL: "http://example.com/healthz",
+		},
+		{
+			name:           "trusted forwarded proto",
+			forceHTTPS:     true,
+			trustForwarded: true,
+			requestURL:     "http://example.com/path",
+			forwardedProto: "https",
+		},
+		{
+			name:           "untrusted forwarded proto",
+			forceHTTPS:     true,
+			requestURL:     "http://example.com/path",
+			forwardedProto: "https",
+			wantLocation:   "https://example.com/path",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled := false
+			m := &Middleware{
+				next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					nextCalled = true
+				}),
+				defaultClient:         &mockClient{},
+				hostClients:           map[string]client.Client{},
+				hostPolicies:          map[string]*hostPolicy{"example.com": {forceHTTPS: tt.hostForceHTTPS}},
+				forceHTTPS:            tt.forceHTTPS,
+				forceHTTPSExemptPaths: []string{"/healthz"},
+				trustForwardedHeaders: tt.trustForwarded,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.requestURL, nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+
+			if tt.wantLocation != "" {
+				assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+				assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+				assert.False(t, nextCalled)
+			} else {
+				assert.True(t, nextCalled)
+			}
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package flecto_traefik_middleware
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// logOutput receives the middleware logs, Traefik collects plugin output from stderr
+var (
+	logOutput io.Writer = os.Stderr
+	logMu     sync.Mutex
+)
+
+// logf writes a log line prefixed with the middleware name.
+func (m *Middleware) logf(format string, args ...interface{}) {
+	line := fmt.Sprintf("%s: %s\n", m.name, fmt.Sprintf(format, args...))
+	logMu.Lock()
+	defer logMu.Unlock()
+	_, _ = io.WriteString(logOutput, line)
+}
@@ -0,0 +1,52 @@
+package flecto_traefik_middleware
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// logBuffer is a concurrency safe buffer, reload tickers may log while a test reads it.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *logBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// captureLogs redirects the middleware logs to a buffer for the duration of the test.
+func captureLogs(t *testing.T) *logBuffer {
+	t.Helper()
+	buf := &logBuffer{}
+	logMu.Lock()
+	original := logOutput
+	logOutput = buf
+	logMu.Unlock()
+	t.Cleanup(func() {
+		logMu.Lock()
+		logOutput = original
+		logMu.Unlock()
+	})
+	return buf
+}
+
+func TestMiddleware_Logf(t *testing.T) {
+	logs := captureLogs(t)
+	m := &Middleware{name: "test-middleware"}
+
+	m.logf("Failed to reload client for %s: %s", "key", "connection refused")
+
+	assert.Equal(t, "test-middleware: Failed to reload client for key: connection refused\n", logs.String())
+}
@@ -0,0 +1,49 @@
+package flecto_traefik_middleware
+
+// Values of metrics_label, selecting the label passed to the MetricsRecorder
+const (
+	metricsLabelHost    = "host"
+	metricsLabelProject = "project"
+)
+
+// metricsLabelDefault labels the requests of hosts without host config in the host mode
+const metricsLabelDefault = "default"
+
+// MetricsRecorder counts the decisions taken by the middleware, labeled by host or project.
+type MetricsRecorder interface {
+	RecordRedirect(label string)
+	RecordPage(label string)
+	RecordPassthrough(label string)
+}
+
+// recordMetrics reports the decision taken for a request of host to the metrics recorder.
+// Decisions answered before rule matching (canonical, maintenance, stale, uri_too_long, test) are not recorded.
+func (m *Middleware) recordMetrics(host, decision string) {
+	switch decision {
+	case decisionRedirect:
+		m.metrics.RecordRedirect(m.metricsLabel(host))
+	case decisionPage, decisionDefaultPage, decisionCapturedPage:
+		m.metrics.RecordPage(m.metricsLabel(host))
+	case decisionPassthrough, decisionBypass:
+		m.metrics.RecordPassthrough(m.metricsLabel(host))
+	}
+}
+
+// metricsLabel returns the label of host: the configured host, or the settings key of its client in the project mode.
+// Hosts without host config share one label, so arbitrary Host headers cannot grow the label cardinality.
+func (m *Middleware) metricsLabel(host string) string {
+	if m.metricsLabelProject {
+		if state := m.clientState(m.clientForHost(host)); state != nil {
+			return state.redactedKey
+		}
+		return metricsLabelDefault
+	}
+	host = hostname(host)
+	m.hostsMu.RLock()
+	_, ok := m.hostClients[host]
+	m.hostsMu.RUnlock()
+	if ok {
+		return host
+	}
+	return metricsLabelDefault
+}
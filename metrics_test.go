@@ -0,0 +1,93 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingMetrics keeps the recorded decisions as "decision label"
+type recordingMetrics struct {
+	records []string
+}
+
+func (r *recordingMetrics) RecordRedirect(label string) {
+	r.records = append(r.records, "redirect "+label)
+}
+
+func (r *recordingMetrics) RecordPage(label string) {
+	r.records = append(r.records, "page "+label)
+}
+
+func (r *recordingMetrics) RecordPassthrough(label string) {
+	r.records = append(r.records, "passthrough "+label)
+}
+
+func TestMiddleware_ServeHTTP_Metrics(t *testing.T) {
+	newClient := func() *mockClient {
+		return &mockClient{
+			redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+				if uri != "/old" {
+					return nil, ""
+				}
+				return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusFound}, "/new"
+			},
+			pageMatch: func(hostname, uri string) *types.Page {
+				if uri != "/robots.txt" {
+					return nil
+				}
+				return &types.Page{Type: types.PageTypeBasic, Path: "/robots.txt", Content: "User-agent: *"}
+			},
+		}
+	}
+	defaultMock, frMock := newClient(), newClient()
+
+	requests := []string{
+		"http://example.fr/old",
+		"http://example.fr:443/robots.txt",
+		"http://example.com/old",
+		"http://unknown.example/other",
+	}
+
+	tests := []struct {
+		name        string
+		projectMode bool
+		want        []string
+	}{
+		{
+			name: "labeled by host",
+			want: []string{"redirect example.fr", "page example.fr", "redirect default", "passthrough default"},
+		},
+		{
+			name:        "labeled by project",
+			projectMode: true,
+			want:        []string{"redirect proj-fr", "page proj-fr", "redirect proj", "passthrough proj"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics := &recordingMetrics{}
+			m := &Middleware{
+				next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				defaultClient: defaultMock,
+				hostClients:   map[string]client.Client{"example.fr": frMock},
+				states: map[client.Client]*clientState{
+					defaultMock: newClientState("proj", defaultMock),
+					frMock:      newClientState("proj-fr", frMock),
+				},
+				metrics:             metrics,
+				metricsLabelProject: tt.projectMode,
+			}
+
+			for _, url := range requests {
+				m.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+			}
+
+			assert.Equal(t, tt.want, metrics.records)
+		})
+	}
+}
@@ -2,25 +2,137 @@ package flecto_traefik_middleware
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
-	"os"
+	"net/url"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/flectolab/flecto-manager/common/types"
 	"github.com/flectolab/go-client"
 )
 
+// Values of match_order, selecting whether redirects or pages are matched first
+const (
+	matchOrderRedirectFirst = "redirect-first"
+	matchOrderPageFirst     = "page-first"
+)
+
+// Values of conflict_policy, selecting what is served when a redirect and a page match the same uri
+const (
+	conflictPolicyRedirectWins = "redirect-wins"
+	conflictPolicyPageWins     = "page-wins"
+	conflictPolicyError        = "error"
+)
+
+// Values of match_mode, selecting the uri passed to the rule matching
+const (
+	matchModeRaw     = "raw"
+	matchModeDecoded = "decoded"
+)
+
+// ruleTrailer is the trailer carrying the matched rule when debug_trailer is enabled
+const ruleTrailer = "X-Middleware-Flecto-Rule"
+
 type Middleware struct {
 	name          string
 	next          http.Handler
 	defaultClient client.Client
 	hostClients   map[string]client.Client
+	hostPolicies  map[string]*hostPolicy
 	cancelCtx     context.Context
+	clock         clock
 	debug         bool
+	debugTrailer  bool
+	accessLog     bool
+
+	// hostsMu guards hostClients, hostPolicies and states, which UpdateHostConfig changes while serving
+	hostsMu sync.RWMutex
+	// updateMu serializes the calls to UpdateHostConfig
+	updateMu sync.Mutex
+	// baseSettings are the root client settings, inherited by the host configs of UpdateHostConfig
+	baseSettings ClientSettings
+	rolloutHash  string
+	// minIntervalCheck is checked against the settings of UpdateHostConfig as validateConfig does, 0 when not set
+	minIntervalCheck time.Duration
+
+	// secondaryDefaultClient replaces defaultClient until it has loaded rules, nil when not configured
+	secondaryDefaultClient client.Client
+
+	encodeRedirectTarget bool
+	defaultRedirectCode  int
+	matchPathOnly        bool
+	matchDecoded         bool
+	matchScheme          bool
+	matchMethod          bool
+	pageFirst            bool
+	conflictError        bool
+	redirectsDisabled    bool
+	pagesDisabled        bool
+	bypassPaths          []string
+
+	defaultPageContentType string
+	staleWhileRevalidate   time.Duration
+	chunkedPageThreshold   int64
+	defaultRobotsTxt       string
+	defaultFavicon         bool
+	skipEmptyPages         bool
+	// capturePageStatuses are the statuses of the next handler replaced by the page of capturePagePath
+	capturePageStatuses []int
+	capturePagePath     string
+
+	forceHTTPS            bool
+	forceHTTPSExemptPaths []string
+	trustForwardedHeaders bool
+
+	// states tracks the reload outcome of each client created by this middleware
+	states      map[client.Client]*clientState
+	staleAfter  time.Duration
+	staleStatus int
+
+	// readyHosts are the hosts whose clients gate ReadyHandler, every client when empty
+	readyHosts []string
+
+	// coldStartPage is served while the client has not loaded any rules, empty to pass through
+	coldStartPage       string
+	coldStartRetryAfter string
+
+	reloadFailureThreshold int
+	reloadCooldown         time.Duration
+	initRetries            int
+	initRetryBackoff       time.Duration
+	sharedClients          bool
+
+	slowMatchThreshold time.Duration
+	serveTimeout       time.Duration
+	serveTimeoutStatus int
+
+	syntheticErrorFormat string
+	adminToken           string
+	maxRequestBodyBytes  int64
+	maxURILength         int
+	maxURILengthStrict   bool
+
+	onReload func(key string, version int, err error)
+	events   chan<- MatchEvent
+
+	metrics             MetricsRecorder
+	metricsLabelProject bool
+	// openMetrics keeps the counts exposed by MetricsHandler, nil unless open_metrics is enabled
+	openMetrics *openMetrics
 }
 
+// defaultReloadCooldown pauses the reloads of a failing client when reload_cooldown is not set
+const defaultReloadCooldown = 5 * time.Minute
+
+// defaultInitRetryBackoff is the first wait between init retries when init_retry_backoff is not set
+const defaultInitRetryBackoff = time.Second
+
 // clientFactory allows overriding client creation in tests
 var clientFactory = func(cfg *client.Config) client.Client {
 	return client.New(cfg)
@@ -34,26 +146,37 @@ var (
 	cancelFuncsMu sync.Mutex
 )
 
-func reloadClient(name, key string, c client.Client) func() {
-	return func() {
-		err := c.Reload()
-		if err != nil {
-			_, _ = os.Stderr.WriteString(fmt.Sprintf("%s: Failed to reload client for %s: %s\n", name, key, strings.TrimSpace(err.Error())))
-		}
-	}
-}
-
 // settingsKey generates a unique key based on the client settings
+// Clients stripping a prefix match differently, manager headers select other rules and min_tls_version changes the
+// connections to the manager: they are not shared with the others.
 func settingsKey(settings ClientSettings) string {
-	return settings.ManagerUrl + "|" + settings.NamespaceCode + "|" + settings.ProjectCode
+	key := strings.Join(managerUrls(settings), ",") + "|" + namespaceCode(settings) + "|" + settings.ProjectCode
+	if settings.StripPrefix != "" {
+		key += "|" + settings.StripPrefix
+		if settings.RestorePrefix {
+			key += "+"
+		}
+	}
+	if len(settings.ManagerHeaders) > 0 {
+		headers := make([]string, 0, len(settings.ManagerHeaders))
+		for name, value := range settings.ManagerHeaders {
+			headers = append(headers, http.CanonicalHeaderKey(name)+"="+value)
+		}
+		sort.Strings(headers)
+		key += "|" + strings.Join(headers, ",")
+	}
+	if settings.MinTLSVersion != "" {
+		key += "|tls" + settings.MinTLSVersion
+	}
+	return key
 }
 
-func startTicker(ctx context.Context, interval time.Duration, work func()) {
-	ticker := time.NewTicker(interval)
+func startTicker(ctx context.Context, clk clock, interval time.Duration, work func()) {
+	ticker := clk.NewTicker(interval)
 	go func() {
 		for {
 			select {
-			case <-ticker.C:
+			case <-ticker.C():
 				work()
 			case <-ctx.Done():
 				ticker.Stop()
@@ -63,30 +186,151 @@ func startTicker(ctx context.Context, interval time.Duration, work func()) {
 	}()
 }
 
-// createClient creates a new client and starts its reload ticker.
+// reloadClient returns the ticker work of a client, skipped while its circuit breaker is open.
+func (m *Middleware) reloadClient(state *clientState) func() {
+	return func() {
+		if state.breakerState(time.Now()) == breakerOpen {
+			return
+		}
+		_ = m.reload(state)
+	}
+}
+
+// reload reloads the rules of a client and records the outcome.
+func (m *Middleware) reload(state *clientState) error {
+	err := state.client.Reload()
+	m.reportReload(state, err, state.recordReload(err))
+	return err
+}
+
+// reportReload logs the outcome of a reload of state, opened when it opened the circuit breaker, and passes it to OnReload.
+func (m *Middleware) reportReload(state *clientState, err error, opened bool) {
+	if err != nil {
+		m.logf("Failed to reload client for %s: %s", state.redactedKey, strings.TrimSpace(err.Error()))
+	}
+	if opened {
+		m.logf("Pausing reloads of %s for %s after %d consecutive failures", state.redactedKey, state.breakerCooldown, state.breakerThreshold)
+	}
+	if m.onReload != nil {
+		m.onReload(state.redactedKey, state.client.GetStateVersion(), err)
+	}
+}
+
+// createClient creates a new client and starts its reload ticker, or takes it from the shared pool with shared_clients.
 // Init errors are ignored to avoid blocking middleware startup - the ticker will retry via Reload.
 func (m *Middleware) createClient(settings ClientSettings) (client.Client, error) {
+	if m.sharedClients {
+		return m.pooledClient(settings)
+	}
+	state, err := m.startClient(m.cancelCtx, settings)
+	if err != nil {
+		return nil, err
+	}
+	return state.client, nil
+}
+
+// startClient creates a client and starts its reload ticker, stopped when ctx is done.
+func (m *Middleware) startClient(ctx context.Context, settings ClientSettings) (*clientState, error) {
+	state, interval, err := m.newState(settings)
+	if err != nil {
+		return nil, err
+	}
+	ctx, state.stop = context.WithCancel(ctx)
+	m.hostsMu.Lock()
+	m.states[state.client] = state
+	m.hostsMu.Unlock()
+	startTicker(ctx, m.clock, interval, m.reloadClient(state))
+
+	return state, nil
+}
+
+// newState creates the client of settings and loads its rules, returning its state and reload interval.
+func (m *Middleware) newState(settings ClientSettings) (*clientState, time.Duration, error) {
 	key := settingsKey(settings)
 	clientCfg, err := transformSettings(m.name, settings)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	c := clientFactory(clientCfg)
+	if settings.StripPrefix != "" {
+		c = &prefixClient{Client: c, prefix: settings.StripPrefix, restore: settings.RestorePrefix}
+	}
 	// Ignore Init error to avoid blocking middleware startup
 	// The ticker will retry via Reload
-	err = c.Init()
+	state := newClientState(key, c)
+	state.redactedKey = settingsKey(redactSettings(settings))
+	state.breakerThreshold = m.reloadFailureThreshold
+	state.breakerCooldown = m.reloadCooldown
+	err = m.initClient(state.redactedKey, c)
+	state.recordReload(err)
 	if err != nil {
-		_, _ = os.Stderr.WriteString(fmt.Sprintf("%s: Failed to initialize client for %s: %s\n", m.name, key, strings.TrimSpace(err.Error())))
+		m.logf("Failed to initialize client for %s: %s", state.redactedKey, strings.TrimSpace(err.Error()))
 	}
-	startTicker(m.cancelCtx, clientCfg.IntervalCheck, reloadClient(m.name, key, c))
+	return state, clientCfg.IntervalCheck, nil
+}
 
-	return c, nil
+// initClient loads the rules of c, retrying up to initRetries times with a doubling backoff.
+func (m *Middleware) initClient(key string, c client.Client) error {
+	err := c.Init()
+	backoff := m.initRetryBackoff
+	for attempt := 1; err != nil && attempt <= m.initRetries; attempt++ {
+		m.logf("Failed to initialize client for %s, retry %d/%d in %s: %s", key, attempt, m.initRetries, backoff, strings.TrimSpace(err.Error()))
+		select {
+		case <-m.cancelCtx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		err = c.Init()
+	}
+	return err
 }
 
 func New(ctx context.Context, next http.Handler, config *Config, name string) (http.Handler, error) {
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("%s: %w", name, err)
 	}
+	staleAfter, err := parseOptionalDuration("stale_after", config.StaleAfter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	slowMatchThreshold, err := parseOptionalDuration("slow_match_threshold", config.SlowMatchThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	serveTimeout, err := parseOptionalDuration("serve_timeout", config.ServeTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	staleWhileRevalidate, err := parseOptionalDuration("stale_while_revalidate", config.StaleWhileRevalidate)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	reloadCooldown, err := parseOptionalDuration("reload_cooldown", config.ReloadCooldown)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if reloadCooldown == 0 {
+		reloadCooldown = defaultReloadCooldown
+	}
+	initRetryBackoff, err := parseOptionalDuration("init_retry_backoff", config.InitRetryBackoff)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if initRetryBackoff == 0 {
+		initRetryBackoff = defaultInitRetryBackoff
+	}
+	coldStartRetryAfter, err := parseOptionalDuration("cold_start_retry_after", config.ColdStartRetryAfter)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	if coldStartRetryAfter == 0 {
+		coldStartRetryAfter = defaultColdStartRetryAfter
+	}
+	minIntervalCheck, err := parseOptionalDuration("min_interval_check", config.MinIntervalCheck)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
 
 	// Cancel any previous instance's goroutines for this middleware name
 	// This handles Traefik config reloads where New() is called again with the same name
@@ -99,11 +343,83 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 	cancelFuncsMu.Unlock()
 
 	m := &Middleware{
-		name:        name,
-		next:        next,
-		hostClients: make(map[string]client.Client),
-		cancelCtx:   cancelCtx,
-		debug:       config.Debug,
+		name:         name,
+		next:         next,
+		hostClients:  make(map[string]client.Client),
+		hostPolicies: make(map[string]*hostPolicy),
+		cancelCtx:    cancelCtx,
+		clock:        systemClock,
+		debug:        config.Debug,
+		debugTrailer: config.DebugTrailer,
+		accessLog:    config.AccessLog,
+
+		encodeRedirectTarget: !config.PreserveRedirectTarget,
+		defaultRedirectCode:  config.DefaultRedirectCode,
+		matchPathOnly:        config.MatchPathOnly,
+		matchDecoded:         config.MatchMode == matchModeDecoded,
+		matchScheme:          config.MatchScheme,
+		matchMethod:          config.MatchMethod,
+		pageFirst:            config.MatchOrder == matchOrderPageFirst || config.ConflictPolicy == conflictPolicyPageWins,
+		conflictError:        config.ConflictPolicy == conflictPolicyError,
+		redirectsDisabled:    config.RedirectsDisabled,
+		pagesDisabled:        config.PagesDisabled,
+		bypassPaths:          config.BypassPaths,
+
+		defaultPageContentType: config.DefaultPageContentType,
+		staleWhileRevalidate:   staleWhileRevalidate,
+		chunkedPageThreshold:   config.ChunkedPageThreshold,
+		defaultRobotsTxt:       config.DefaultRobotsTxt,
+		defaultFavicon:         config.DefaultFavicon,
+		skipEmptyPages:         config.SkipEmptyPages,
+		capturePageStatuses:    config.CapturePageStatuses,
+		capturePagePath:        config.CapturePagePath,
+
+		forceHTTPS:            config.ForceHTTPS,
+		forceHTTPSExemptPaths: config.ForceHTTPSExemptPaths,
+		trustForwardedHeaders: config.TrustForwardedHeaders,
+
+		states:      make(map[client.Client]*clientState),
+		staleAfter:  staleAfter,
+		staleStatus: config.StaleStatus,
+
+		readyHosts: config.ReadyHosts,
+
+		coldStartPage:       config.ColdStartPage,
+		coldStartRetryAfter: strconv.Itoa(int(coldStartRetryAfter.Seconds())),
+
+		reloadFailureThreshold: config.ReloadFailureThreshold,
+		reloadCooldown:         reloadCooldown,
+		initRetries:            config.InitRetries,
+		initRetryBackoff:       initRetryBackoff,
+		sharedClients:          config.SharedClients,
+
+		slowMatchThreshold: slowMatchThreshold,
+		serveTimeout:       serveTimeout,
+		serveTimeoutStatus: config.ServeTimeoutStatus,
+
+		syntheticErrorFormat: config.SyntheticErrorFormat,
+		adminToken:           config.AdminToken,
+		maxRequestBodyBytes:  config.MaxRequestBodyBytes,
+		maxURILength:         config.MaxUriLength,
+		maxURILengthStrict:   config.MaxUriLengthStrict,
+
+		onReload: config.OnReload,
+		events:   config.Events,
+
+		metrics:             config.Metrics,
+		metricsLabelProject: config.MetricsLabel == metricsLabelProject,
+
+		baseSettings:     config.ClientSettings,
+		rolloutHash:      config.RolloutHash,
+		minIntervalCheck: minIntervalCheck,
+	}
+	if config.OpenMetrics {
+		m.openMetrics = newOpenMetrics()
+		if m.metrics != nil {
+			m.metrics = metricsRecorders{m.metrics, m.openMetrics}
+		} else {
+			m.metrics = m.openMetrics
+		}
 	}
 
 	// Local cache to reuse clients with same settings within this middleware
@@ -119,67 +435,584 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		m.defaultClient = defaultClient
 		localClients[key] = defaultClient
 	}
+	if config.SecondaryDefault != nil {
+		m.secondaryDefaultClient, err = m.sharedClient(localClients, mergeSettings(config.ClientSettings, *config.SecondaryDefault))
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// Create clients for each host config
 	for _, hc := range config.HostConfigs {
-		mergedSettings := mergeSettings(config.ClientSettings, hc.ClientSettings)
-		key := settingsKey(mergedSettings)
-
-		// Reuse client if same settings already created for this middleware
-		hostClient, exists := localClients[key]
-		if !exists {
-			var err error
-			hostClient, err = m.createClient(mergedSettings)
+		hostClient, err := m.sharedClient(localClients, mergeSettings(config.ClientSettings, hc.ClientSettings))
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := newHostPolicy(hc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		policy.rolloutHash = config.RolloutHash
+		if hc.MigrateTo != nil {
+			policy.migrationClient, err = m.sharedClient(localClients, mergeSettings(config.ClientSettings, *hc.MigrateTo))
 			if err != nil {
 				return nil, err
 			}
-			localClients[key] = hostClient
 		}
 
 		for _, host := range hc.Hosts {
 			m.hostClients[host] = hostClient
+			m.hostPolicies[host] = policy
+		}
+		if hc.Default {
+			m.defaultClient = hostClient
 		}
 	}
 
+	if m.debug {
+		m.logClientSummary(localClients)
+	}
+	if config.DumpConfig {
+		m.dumpConfig(config)
+	}
+
 	return m, nil
 }
 
+// sharedClient returns the client of settings, reusing the one already created by this middleware for the same settings.
+func (m *Middleware) sharedClient(localClients map[string]client.Client, settings ClientSettings) (client.Client, error) {
+	key := settingsKey(settings)
+	if c, exists := localClients[key]; exists {
+		return c, nil
+	}
+	c, err := m.createClient(settings)
+	if err != nil {
+		return nil, err
+	}
+	localClients[key] = c
+	return c, nil
+}
+
+// logClientSummary logs the client serving each host, sorted for reproducible output.
+func (m *Middleware) logClientSummary(clients map[string]client.Client) {
+	keys := make(map[client.Client]string, len(clients))
+	for _, c := range clients {
+		if state := m.clientState(c); state != nil {
+			keys[c] = state.redactedKey
+		}
+	}
+
+	if m.defaultClient != nil {
+		m.logf("default client: %s", keys[m.defaultClient])
+	} else {
+		m.logf("default client: none, unmatched hosts are passed through")
+	}
+
+	hosts := make([]string, 0, len(m.hostClients))
+	for host := range m.hostClients {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		m.logf("host %s: %s", host, keys[m.hostClients[host]])
+	}
+}
+
+// dumpConfig logs the effective settings of the default client and of each host, secrets redacted.
+func (m *Middleware) dumpConfig(config *Config) {
+	if settings, ok := config.defaultSettings(); ok {
+		m.logf("default settings: %s", formatSettings(settings))
+	}
+	for _, hc := range config.HostConfigs {
+		settings := formatSettings(mergeSettings(config.ClientSettings, hc.ClientSettings))
+		for _, host := range hc.Hosts {
+			m.logf("host %s settings: %s", host, settings)
+		}
+	}
+}
+
+// formatSettings formats the effective settings with their configuration names, secrets redacted.
+func formatSettings(settings ClientSettings) string {
+	settings.NamespaceCode = namespaceCode(settings)
+	b, _ := json.Marshal(redactSettings(settings))
+	return string(b)
+}
+
+// hostname removes the port from host if present (example.com:443 -> example.com, [::1]:443 -> [::1])
+// IPv6 literals keep their brackets. IndexByte avoids allocating a slice on every request
+func hostname(host string) string {
+	if strings.HasPrefix(host, "[") {
+		if i := strings.IndexByte(host, ']'); i != -1 {
+			return host[:i+1]
+		}
+		return host
+	}
+	// Several colons are an IPv6 literal without brackets, which has no port
+	if i := strings.IndexByte(host, ':'); i != -1 && strings.LastIndexByte(host, ':') == i {
+		return host[:i]
+	}
+	return host
+}
+
 func (m *Middleware) clientForHost(host string) client.Client {
-	// Remove port if present (example.com:443 -> example.com)
-	h := strings.Split(host, ":")[0]
-	if c, ok := m.hostClients[h]; ok {
+	m.hostsMu.RLock()
+	c, ok := m.hostClients[hostname(host)]
+	m.hostsMu.RUnlock()
+	if ok {
 		return c
 	}
+	// The secondary default only stands in until the default client loads its first rules
+	if m.secondaryDefaultClient != nil && m.defaultClient.GetStateVersion() == 0 {
+		return m.secondaryDefaultClient
+	}
 	return m.defaultClient
 }
 
+// clientState returns the reload state of c, nil when c was not created by this middleware.
+func (m *Middleware) clientState(c client.Client) *clientState {
+	m.hostsMu.RLock()
+	defer m.hostsMu.RUnlock()
+	return m.states[c]
+}
+
+// ResolveHost returns the settings key of the client serving host and whether it is the default client.
+// The key is empty when no client serves host.
+func (m *Middleware) ResolveHost(host string) (key string, isDefault bool) {
+	m.hostsMu.RLock()
+	c, ok := m.hostClients[hostname(host)]
+	m.hostsMu.RUnlock()
+	if !ok {
+		c = m.defaultClient
+	}
+	if state := m.clientState(c); state != nil {
+		key = state.redactedKey
+	}
+	return key, !ok
+}
+
+// matchPath reports whether path matches one of the patterns.
+// A pattern ending with * matches as a prefix, otherwise the path must be equal.
+func matchPath(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(path, pattern[:len(pattern)-1]) {
+				return true
+			}
+		} else if path == pattern {
+			return true
+		}
+	}
+	return false
+}
+
 func (m *Middleware) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if !m.accessLog && m.events == nil && m.metrics == nil {
+		m.serve(rw, req)
+		return
+	}
+	// Read before serving, the next handler may rewrite the request
+	host, uri := req.Host, req.URL.RequestURI()
+	decision, status := m.serve(rw, req)
+	if m.accessLog {
+		m.logAccess(host, uri, decision, status)
+	}
+	if m.events != nil {
+		m.publishEvent(MatchEvent{Host: host, URI: uri, Decision: decision, Status: status})
+	}
+	if m.metrics != nil {
+		m.recordMetrics(host, decision)
+	}
+}
+
+// serve handles req and returns the decision taken, with the status written by the middleware.
+// The status is 0 when the request is passed to the next handler.
+func (m *Middleware) serve(rw http.ResponseWriter, req *http.Request) (string, int) {
+	// Infrastructure endpoints are never redirected nor overridden
+	if matchPath(m.bypassPaths, req.URL.Path) {
+		m.next.ServeHTTP(rw, req)
+		return decisionBypass, 0
+	}
+
+	policy := m.policyForHost(req.Host)
+	if policy != nil && policy.responseHeaders != nil {
+		rw = &responseHeaderWriter{ResponseWriter: rw, headers: policy.responseHeaders}
+	}
+
+	// Overlong uris, typically sent by scanners, are never matched
+	if m.maxURILength > 0 {
+		if length := len(req.URL.RequestURI()); length > m.maxURILength {
+			return m.serveURITooLong(rw, req, length)
+		}
+	}
+	if target := m.canonicalURL(req, policy); target != "" {
+		m.serveCanonicalRedirect(rw, req, target)
+		return decisionCanonical, http.StatusPermanentRedirect
+	}
+
+	// Maintenance bypasses rule matching, exempt paths go straight to the next handler
+	if policy != nil && policy.maintenance {
+		if matchPath(policy.exemptPaths, req.URL.Path) {
+			m.serveNext(rw, req)
+			return decisionPassthrough, 0
+		}
+		policy.serveMaintenance(rw, m.syntheticErrorFormat)
+		return decisionMaintenance, http.StatusServiceUnavailable
+	}
+
+	// Malformed requests without host or path never match a rule
+	if req.Host == "" || req.URL.Path == "" {
+		if m.debug {
+			m.logf("Skipping request with empty host or path: host=%q path=%q", req.Host, req.URL.Path)
+		}
+		m.serveNext(rw, req)
+		return decisionPassthrough, 0
+	}
+
 	c := m.clientForHost(req.Host)
+	if policy != nil && policy.migrationClient != nil && policy.migrates(m.migrationKey(req)) {
+		c = policy.migrationClient
+	}
 
 	// No client for this host, skip to next handler
 	if c == nil {
-		m.next.ServeHTTP(rw, req)
-		return
+		m.serveNext(rw, req)
+		return decisionPassthrough, 0
+	}
+
+	// Rules are no longer trusted once the last successful reload is too old
+	if m.staleAfter > 0 {
+		if state := m.clientState(c); state != nil && state.isStale(time.Now(), m.staleAfter) {
+			if m.staleStatus != 0 {
+				writeSyntheticError(rw, m.syntheticErrorFormat, m.staleStatus, http.StatusText(m.staleStatus))
+				return decisionStale, m.staleStatus
+			}
+			m.serveNext(rw, req)
+			return decisionPassthrough, 0
+		}
+	}
+
+	// Legacy urls must not reach the next handler before the rules are known
+	if m.coldStartPage != "" && c.GetStateVersion() == 0 {
+		m.serveColdStart(rw)
+		return decisionColdStart, http.StatusServiceUnavailable
 	}
 
+	uri := m.ruleURI(req.URL)
+	var start time.Time
 	if m.debug {
 		rw.Header().Add("X-Middleware-Flecto-Version", fmt.Sprintf("%d", c.GetStateVersion()))
-		rw.Header().Add("X-Middleware-Flecto-Url", fmt.Sprintf("%s%s", req.Host, req.URL.RequestURI()))
+		rw.Header().Add("X-Middleware-Flecto-Url", fmt.Sprintf("%s%s", req.Host, uri))
+		if state := m.clientState(c); state != nil {
+			rw.Header().Add("X-Middleware-Flecto-Client", state.id)
+			if lastError, failures := state.reloadError(); lastError != "" {
+				// Errors may span several lines, which a header value cannot
+				rw.Header().Add("X-Middleware-Flecto-Client-Error", fmt.Sprintf("%d failures: %s", failures, strings.Join(strings.Fields(lastError), " ")))
+			}
+		}
+
+		if testURI := req.Header.Get(testURIHeader); testURI != "" {
+			return decisionTest, m.serveTestURI(rw, req, c, policy, testURI)
+		}
+		start = time.Now()
+	}
+	redirect, target, page, matched := m.matchRulesWithin(c, policy, req, uri)
+	if !matched {
+		return m.serveMatchTimeout(rw, req)
+	}
+	if m.debug {
+		rw.Header().Add("Server-Timing", serverTiming(time.Since(start)))
+		if redirect != nil {
+			m.traceMatch(rw, req, uri, "redirect "+redirect.Source+" -> "+target)
+		} else if page != nil {
+			m.traceMatch(rw, req, uri, "page "+page.Path)
+		}
 	}
-	redirect, target := c.RedirectMatch(req.Host, req.URL.RequestURI())
 	if redirect != nil {
+		return decisionRedirect, m.serveRedirect(rw, req, redirect, target)
+	}
+	if page != nil {
+		return decisionPage, m.servePage(rw, req, policy, page)
+	}
+	if m.serveDefaultPage(rw, req) {
+		return decisionDefaultPage, http.StatusOK
+	}
+	return m.serveNextCaptured(rw, req, c, policy)
+}
+
+// serveNext passes req to the next handler, capping its body when max_request_body_bytes is set.
+func (m *Middleware) serveNext(rw http.ResponseWriter, req *http.Request) {
+	if m.maxRequestBodyBytes > 0 && req.Body != nil {
+		req.Body = http.MaxBytesReader(rw, req.Body, m.maxRequestBodyBytes)
+	}
+	m.next.ServeHTTP(rw, req)
+}
+
+// ruleURI returns the uri of u passed to the rule matching, decoded in the decoded match_mode.
+func (m *Middleware) ruleURI(u *url.URL) string {
+	if !m.matchDecoded {
+		return u.RequestURI()
+	}
+	if u.RawQuery == "" {
+		return m.rulePath(u)
+	}
+	return m.rulePath(u) + "?" + u.RawQuery
+}
+
+// rulePath returns the path of u passed to the rule matching, decoded in the decoded match_mode.
+func (m *Middleware) rulePath(u *url.URL) string {
+	if m.matchDecoded {
+		return u.Path
+	}
+	return u.EscapedPath()
+}
+
+// matchRulesOrdered matches the redirects and the pages of c against uri, the request uri of u, in the match_order.
+// The first match wins, so at most one of the redirect and the page is returned.
+func (m *Middleware) matchRulesOrdered(c client.Client, policy *hostPolicy, req *http.Request, uri string, u *url.URL) (*types.Redirect, string, *types.Page) {
+	if m.conflictError {
+		return m.matchRulesExclusive(c, policy, req, uri, u)
+	}
+	if m.pageFirst {
+		if page := m.matchPageRule(c, policy, req, uri); page != nil {
+			return nil, "", page
+		}
+		redirect, target := m.matchRedirectRule(c, req, uri, u)
+		return redirect, target, nil
+	}
+	if redirect, target := m.matchRedirectRule(c, req, uri, u); redirect != nil {
+		return redirect, target, nil
+	}
+	return nil, "", m.matchPageRule(c, policy, req, uri)
+}
+
+// matchRulesExclusive matches both the redirects and the pages of c, returning neither when both match
+// so ambiguous rules are never served.
+func (m *Middleware) matchRulesExclusive(c client.Client, policy *hostPolicy, req *http.Request, uri string, u *url.URL) (*types.Redirect, string, *types.Page) {
+	redirect, target := m.matchRedirectRule(c, req, uri, u)
+	page := m.matchPageRule(c, policy, req, uri)
+	if redirect != nil && page != nil {
+		m.logf("Conflicting rules for %s%s: redirect %s and page %s both match, passing through", req.Host, uri, redirect.Source, page.Path)
+		return nil, "", nil
+	}
+	return redirect, target, page
+}
+
+// matchRedirectRule matches the redirect rules unless disabled, logging slow matches in debug.
+func (m *Middleware) matchRedirectRule(c client.Client, req *http.Request, uri string, u *url.URL) (*types.Redirect, string) {
+	if m.redirectsDisabled {
+		return nil, ""
+	}
+	var start time.Time
+	if m.debug {
+		start = time.Now()
+	}
+	redirect, target := m.matchRedirect(c, req, uri, u)
+	if m.debug {
+		m.logSlowMatch("redirect", req.Host, uri, time.Since(start))
+	}
+	// A malformed rule must not send an empty Location, only a 451 may go without target
+	if redirect != nil && target == "" && redirect.Status != RedirectStatusUnavailableLegal {
 		if m.debug {
-			rw.Header().Add("X-Middleware-Flecto-Redirect", fmt.Sprintf("%v", redirect))
+			m.logf("Skipping redirect %s with an empty target for %s%s", redirect.Source, req.Host, uri)
 		}
-		http.Redirect(rw, req, target, redirect.HTTPCode())
-		return
+		return nil, ""
 	}
-	page := c.PageMatch(req.Host, req.URL.RequestURI())
-	if page != nil {
-		rw.Header().Add("Content-Type", page.HTTPContentType())
+	// Over-broad rules may resolve to the request itself, redirecting would loop
+	if redirect != nil && redirect.Status != RedirectStatusUnavailableLegal && isSelfRedirect(target, m.scheme(req), req.Host, uri, u) {
+		if m.debug {
+			m.logf("Skipping redirect %s to the request itself for %s%s", redirect.Source, req.Host, uri)
+		}
+		return nil, ""
+	}
+	return redirect, target
+}
+
+// matchPageRule matches the pages unless disabled, logging slow matches in debug.
+func (m *Middleware) matchPageRule(c client.Client, policy *hostPolicy, req *http.Request, uri string) *types.Page {
+	// Pages have no CORS headers, preflight requests are left to the next handler
+	if m.pagesDisabled || req.Method == http.MethodOptions {
+		return nil
+	}
+	var start time.Time
+	if m.debug {
+		start = time.Now()
+	}
+	page := matchPage(c, policy, req.Host, uri)
+	if m.debug {
+		m.logSlowMatch("page", req.Host, uri, time.Since(start))
+	}
+	if page != nil && page.Content == "" && m.skipEmptyPages {
+		if m.debug {
+			m.logf("Skipping empty page %s for %s%s", page.Path, req.Host, uri)
+		}
+		return nil
+	}
+	return page
+}
+
+// matchRedirect matches the redirect rules of c against uri, the request uri of u.
+// With match_method, method qualified rules are first matched with the method prepended to the uri, then all rules without.
+func (m *Middleware) matchRedirect(c client.Client, req *http.Request, uri string, u *url.URL) (*types.Redirect, string) {
+	if m.matchMethod {
+		if redirect, target := m.matchRedirectScheme(c, req, req.Method+" ", uri, u); redirect != nil && methodQualified(redirect) {
+			return redirect, target
+		}
+	}
+	return m.matchRedirectScheme(c, req, "", uri, u)
+}
+
+// matchRedirectScheme matches the redirect rules of c against prefix followed by uri.
+// With match_scheme, scheme qualified host rules are first matched with the scheme prepended to the host, then all rules without.
+func (m *Middleware) matchRedirectScheme(c client.Client, req *http.Request, prefix, uri string, u *url.URL) (*types.Redirect, string) {
+	if m.matchScheme {
+		if redirect, target := m.matchRedirectHost(c, m.scheme(req)+"://"+req.Host, prefix, uri, u); redirect != nil && schemeQualified(redirect) {
+			return redirect, target
+		}
+	}
+	return m.matchRedirectHost(c, req.Host, prefix, uri, u)
+}
+
+// matchRedirectHost matches the redirect rules of c for host against prefix followed by uri, the request uri of u.
+func (m *Middleware) matchRedirectHost(c client.Client, host, prefix, uri string, u *url.URL) (*types.Redirect, string) {
+	redirect, target := c.RedirectMatch(host, prefix+uri)
+	// Rules without query still match query-bearing requests, the query is kept on the target
+	if redirect == nil && m.matchPathOnly && u.RawQuery != "" {
+		redirect, target = c.RedirectMatch(host, prefix+m.rulePath(u))
+		if target != "" {
+			target = appendQuery(target, u.RawQuery)
+		}
+	}
+	return redirect, target
+}
+
+// serveRedirect redirects req to target and returns the status used.
+func (m *Middleware) serveRedirect(rw http.ResponseWriter, req *http.Request, redirect *types.Redirect, target string) int {
+	if m.debug {
+		rw.Header().Add("X-Middleware-Flecto-Redirect", fmt.Sprintf("%v", redirect))
+	}
+	if m.matchScheme && m.trustForwardedHeaders {
+		addVary(rw.Header(), "X-Forwarded-Proto")
+	}
+	rw = headWriter(rw, req)
+	trailer := m.announceRuleTrailer(rw, req)
+	if m.encodeRedirectTarget {
+		target = encodeRedirectTarget(target)
+	}
+	code := redirectCode(redirect, m.defaultRedirectCode)
+	if code == http.StatusUnavailableForLegalReasons {
+		if target != "" {
+			rw.Header().Set("Link", "<"+target+`>; rel="blocked-by"`)
+		}
+		writeSyntheticError(rw, m.syntheticErrorFormat, code, http.StatusText(code))
+	} else {
+		http.Redirect(rw, req, target, code)
+	}
+	if trailer {
+		rw.Header().Set(ruleTrailer, redirect.Source)
+	}
+	return code
+}
+
+// servePage writes page for a host of policy and returns the status written, which ServeContent may change for Range requests.
+func (m *Middleware) servePage(rw http.ResponseWriter, req *http.Request, policy *hostPolicy, page *types.Page) int {
+	sw := &statusWriter{ResponseWriter: headWriter(rw, req)}
+	rw = sw
+	trailer := m.announceRuleTrailer(rw, req)
+	rw.Header().Add("Content-Type", pageContentType(page, m.defaultPageContentType))
+	if m.staleWhileRevalidate > 0 {
+		cacheControl := rw.Header().Get("Cache-Control")
+		if cacheControl == "" && policy != nil {
+			// The response_headers of the host are only added when absent, the directive would replace them
+			cacheControl = policy.responseHeaders.Get("Cache-Control")
+		}
+		rw.Header().Set("Cache-Control", withStaleWhileRevalidate(cacheControl, m.staleWhileRevalidate))
+	}
+	if req.Method == http.MethodHead {
+		// HEAD answers the headers of the whole page with an empty body, Range is not evaluated
+		rw.Header().Set("Content-Length", "0")
 		rw.WriteHeader(http.StatusOK)
-		_, _ = rw.Write([]byte(page.Content))
-		return
+	} else if trailer || m.chunkedPage(req, page) {
+		// Trailers are only sent with chunked encoding, which a Content-Length would prevent.
+		// The whole page is sent, Range requests are only answered with a Content-Length.
+		rw.WriteHeader(http.StatusOK)
+		if f, ok := rw.(http.Flusher); ok {
+			// Flushing the headers before the body keeps the server from buffering it to compute a Content-Length
+			f.Flush()
+		}
+		_, _ = io.WriteString(rw, page.Content)
+	} else {
+		// ServeContent answers Range and If-Range requests, the Content-Type set above is kept
+		http.ServeContent(rw, req, "", time.Time{}, strings.NewReader(page.Content))
 	}
-	m.next.ServeHTTP(rw, req)
+	if trailer {
+		rw.Header().Set(ruleTrailer, page.Path)
+	}
+	return sw.status
+}
+
+// chunkedPage reports whether page is streamed with chunked encoding instead of a Content-Length.
+// Range requests are served from the whole content, so they are never chunked.
+func (m *Middleware) chunkedPage(req *http.Request, page *types.Page) bool {
+	return m.chunkedPageThreshold > 0 && req.Method != http.MethodHead && req.Header.Get("Range") == "" &&
+		int64(len(page.Content)) >= m.chunkedPageThreshold
+}
+
+// statusWriter records the final status written to the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= http.StatusOK {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// headResponseWriter discards the body of a response, status and headers are kept.
+type headResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (w headResponseWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+// headWriter returns a writer discarding the body for HEAD requests,
+// so redirects and pages answer HEAD the same way whatever they write.
+func headWriter(rw http.ResponseWriter, req *http.Request) http.ResponseWriter {
+	if req.Method != http.MethodHead {
+		return rw
+	}
+	return headResponseWriter{rw}
+}
+
+// announceRuleTrailer declares the matched rule trailer when enabled.
+// Trailers require chunked transfer encoding, so HTTP/1.0 clients are skipped.
+func (m *Middleware) announceRuleTrailer(rw http.ResponseWriter, req *http.Request) bool {
+	if !m.debug || !m.debugTrailer || !req.ProtoAtLeast(1, 1) {
+		return false
+	}
+	rw.Header().Set("Trailer", ruleTrailer)
+	return true
 }
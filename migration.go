@@ -0,0 +1,60 @@
+package flecto_traefik_middleware
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// migrationKey returns the stable key of the client sending req, its IP address.
+// The first X-Forwarded-For entry is only used with trust_forwarded_headers.
+func (m *Middleware) migrationKey(req *http.Request) string {
+	if m.trustForwardedHeaders {
+		if forwarded := req.Header.Get("X-Forwarded-For"); forwarded != "" {
+			if i := strings.IndexByte(forwarded, ','); i != -1 {
+				forwarded = forwarded[:i]
+			}
+			return strings.TrimSpace(forwarded)
+		}
+	}
+	if host, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return host
+	}
+	return req.RemoteAddr
+}
+
+// Values of rollout_hash, the hash bucketing the clients of weighted rollouts
+const (
+	rolloutHashFNV    = "fnv"
+	rolloutHashCRC32  = "crc32"
+	rolloutHashSHA256 = "sha256-mod"
+)
+
+func isRolloutHash(hash string) bool {
+	return hash == "" || hash == rolloutHashFNV || hash == rolloutHashCRC32 || hash == rolloutHashSHA256
+}
+
+// migrates reports whether the client with key is served by the migration client.
+func (p *hostPolicy) migrates(key string) bool {
+	return rolloutBucket(p.rolloutHash, key) < p.migrationWeight
+}
+
+// rolloutBucket returns the bucket of key between 0 and 99 with the hash of rollout_hash:
+// the 32-bit FNV-1a or the IEEE CRC-32 of key, or the first 8 bytes of its SHA-256 read as a big-endian integer, modulo 100.
+func rolloutBucket(hash, key string) uint32 {
+	switch hash {
+	case rolloutHashCRC32:
+		return crc32.ChecksumIEEE([]byte(key)) % 100
+	case rolloutHashSHA256:
+		sum := sha256.Sum256([]byte(key))
+		return uint32(binary.BigEndian.Uint64(sum[:8]) % 100)
+	default:
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		return h.Sum32() % 100
+	}
+}
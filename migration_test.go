@@ -0,0 +1,145 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostPolicy_Migrates(t *testing.T) {
+	policy := &hostPolicy{migrationWeight: 30}
+
+	t.Run("split follows the weight", func(t *testing.T) {
+		migrated := 0
+		for i := 0; i < 10000; i++ {
+			if policy.migrates(fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)) {
+				migrated++
+			}
+		}
+		assert.InDelta(t, 3000, migrated, 300)
+	})
+
+	t.Run("stable per key", func(t *testing.T) {
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("192.168.0.%d", i)
+			assert.Equal(t, policy.migrates(key), policy.migrates(key))
+		}
+	})
+
+	t.Run("bounds", func(t *testing.T) {
+		assert.False(t, (&hostPolicy{migrationWeight: 0}).migrates("192.168.0.1"))
+		assert.True(t, (&hostPolicy{migrationWeight: 100}).migrates("192.168.0.1"))
+	})
+}
+
+func TestRolloutBucket(t *testing.T) {
+	// Buckets computed independently, so other systems can reproduce them
+	tests := []struct {
+		key    string
+		fnv    uint32
+		crc32  uint32
+		sha256 uint32
+	}{
+		{key: "203.0.113.7", fnv: 36, crc32: 94, sha256: 75},
+		{key: "192.168.0.1", fnv: 95, crc32: 24, sha256: 55},
+		{key: "2001:db8::1", fnv: 87, crc32: 94, sha256: 29},
+	}
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			assert.Equal(t, tt.fnv, rolloutBucket("", tt.key))
+			assert.Equal(t, tt.fnv, rolloutBucket(rolloutHashFNV, tt.key))
+			assert.Equal(t, tt.crc32, rolloutBucket(rolloutHashCRC32, tt.key))
+			assert.Equal(t, tt.sha256, rolloutBucket(rolloutHashSHA256, tt.key))
+		})
+	}
+
+	t.Run("split follows the weight with every hash", func(t *testing.T) {
+		for _, hash := range []string{rolloutHashFNV, rolloutHashCRC32, rolloutHashSHA256} {
+			policy := &hostPolicy{migrationWeight: 30, rolloutHash: hash}
+			migrated := 0
+			for i := 0; i < 10000; i++ {
+				if policy.migrates(fmt.Sprintf("10.%d.%d.%d", i/65536, (i/256)%256, i%256)) {
+					migrated++
+				}
+			}
+			assert.InDelta(t, 3000, migrated, 300, hash)
+		}
+	})
+}
+
+func TestMiddleware_MigrationKey(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RemoteAddr = "192.168.0.1:51234"
+	req.Header.Set("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+
+	assert.Equal(t, "192.168.0.1", (&Middleware{}).migrationKey(req))
+	assert.Equal(t, "203.0.113.7", (&Middleware{trustForwardedHeaders: true}).migrationKey(req))
+}
+
+func TestMiddleware_ServeHTTP_Migration(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+	clientFactory = func(cfg *client.Config) client.Client {
+		project := cfg.ProjectCode
+		return &mockClient{
+			redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+				return &types.Redirect{Source: uri, Target: "/" + project, Status: types.RedirectStatusFound}, "/" + project
+			},
+		}
+	}
+
+	config := &Config{
+		ClientSettings: ClientSettings{
+			ManagerUrl:    "http://localhost:8080",
+			NamespaceCode: "ns",
+			TokenJWT:      "token",
+		},
+		HostConfigs: []HostConfig{
+			{
+				Hosts:          []string{"example.com"},
+				ClientSettings: ClientSettings{ProjectCode: "old-proj"},
+				MigrateTo:      &ClientSettings{ProjectCode: "new-proj"},
+				MigrateWeight:  50,
+			},
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler, err := New(ctx, http.NotFoundHandler(), config, "test-migration")
+	assert.NoError(t, err)
+
+	serve := func(remoteAddr string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/path", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Header().Get("Location")
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		remoteAddr := fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		location := serve(remoteAddr)
+		counts[location]++
+		assert.Equal(t, location, serve(remoteAddr), "client %s switched project", remoteAddr)
+	}
+	assert.Len(t, counts, 2)
+	assert.InDelta(t, 500, counts["/new-proj"], 100)
+	assert.Equal(t, 1000, counts["/new-proj"]+counts["/old-proj"])
+}
+
+func TestValidateHostPolicy_Migration(t *testing.T) {
+	err := validateHostPolicy(0, HostConfig{MigrateTo: &ClientSettings{ProjectCode: "new"}, MigrateWeight: 101})
+	assert.EqualError(t, err, "host_configs[0]: migrate_weight must be between 0 and 100")
+
+	err = validateHostPolicy(2, HostConfig{MigrateTo: &ClientSettings{}, MigrateWeight: 10})
+	assert.EqualError(t, err, "host_configs[2]: migrate_to: project_code is required")
+
+	assert.NoError(t, validateHostPolicy(0, HostConfig{MigrateTo: &ClientSettings{ProjectCode: "new"}, MigrateWeight: 10}))
+}
@@ -0,0 +1,163 @@
+package flecto_traefik_middleware
+
+import (
+	"bufio"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// openMetricsContentType is the content type of the OpenMetrics text exposition
+const openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// decisionCounts counts the requests of a label by recorded decision.
+type decisionCounts struct {
+	redirects    uint64
+	pages        uint64
+	passthroughs uint64
+}
+
+// openMetrics is the MetricsRecorder enabled by open_metrics, keeping the counts exposed by MetricsHandler.
+type openMetrics struct {
+	mu     sync.Mutex
+	counts map[string]*decisionCounts
+}
+
+func newOpenMetrics() *openMetrics {
+	return &openMetrics{counts: make(map[string]*decisionCounts)}
+}
+
+func (o *openMetrics) RecordRedirect(label string) {
+	o.mu.Lock()
+	o.labelCounts(label).redirects++
+	o.mu.Unlock()
+}
+
+func (o *openMetrics) RecordPage(label string) {
+	o.mu.Lock()
+	o.labelCounts(label).pages++
+	o.mu.Unlock()
+}
+
+func (o *openMetrics) RecordPassthrough(label string) {
+	o.mu.Lock()
+	o.labelCounts(label).passthroughs++
+	o.mu.Unlock()
+}
+
+// labelCounts returns the counts of label, created on first use, mu must be held.
+func (o *openMetrics) labelCounts(label string) *decisionCounts {
+	counts, ok := o.counts[label]
+	if !ok {
+		counts = &decisionCounts{}
+		o.counts[label] = counts
+	}
+	return counts
+}
+
+// snapshot returns a copy of the counts and their labels in order.
+func (o *openMetrics) snapshot() ([]string, map[string]decisionCounts) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	labels := make([]string, 0, len(o.counts))
+	counts := make(map[string]decisionCounts, len(o.counts))
+	for label, c := range o.counts {
+		labels = append(labels, label)
+		counts[label] = *c
+	}
+	sort.Strings(labels)
+	return labels, counts
+}
+
+// metricsRecorders forwards the decisions to several recorders, e.g. Config.Metrics and open_metrics.
+type metricsRecorders []MetricsRecorder
+
+func (r metricsRecorders) RecordRedirect(label string) {
+	for _, recorder := range r {
+		recorder.RecordRedirect(label)
+	}
+}
+
+func (r metricsRecorders) RecordPage(label string) {
+	for _, recorder := range r {
+		recorder.RecordPage(label)
+	}
+}
+
+func (r metricsRecorders) RecordPassthrough(label string) {
+	for _, recorder := range r {
+		recorder.RecordPassthrough(label)
+	}
+}
+
+// MetricsHandler returns a handler exposing the metrics in the OpenMetrics text format, without any client library.
+// The requests are counted by decision when open_metrics is enabled, the reloads of the clients always are.
+func (m *Middleware) MetricsHandler() http.Handler {
+	return http.HandlerFunc(m.serveMetrics)
+}
+
+// labelEscaper escapes the label values of the exposition
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (m *Middleware) serveMetrics(rw http.ResponseWriter, req *http.Request) {
+	rw.Header().Set("Content-Type", openMetricsContentType)
+	w := bufio.NewWriter(rw)
+	defer w.Flush()
+
+	if m.openMetrics != nil {
+		labelName := metricsLabelHost
+		if m.metricsLabelProject {
+			labelName = metricsLabelProject
+		}
+		labels, counts := m.openMetrics.snapshot()
+		writeMetricFamily(w, "flecto_requests", "counter", "Requests handled by the middleware, by decision.")
+		for _, label := range labels {
+			c := counts[label]
+			label = labelName + `="` + labelEscaper.Replace(label) + `"`
+			writeMetric(w, "flecto_requests_total", label+`,decision="redirect"`, c.redirects)
+			writeMetric(w, "flecto_requests_total", label+`,decision="page"`, c.pages)
+			writeMetric(w, "flecto_requests_total", label+`,decision="passthrough"`, c.passthroughs)
+		}
+	}
+
+	states := m.sortedStates()
+	clientLabels := make([]string, len(states))
+	for i, state := range states {
+		clientLabels[i] = `client="` + labelEscaper.Replace(state.redactedKey) + `"`
+	}
+	writeMetricFamily(w, "flecto_reloads", "counter", "Initial loads and reloads of the rules of a client.")
+	for i, state := range states {
+		reloads, _ := state.reloadCounts()
+		writeMetric(w, "flecto_reloads_total", clientLabels[i], uint64(reloads))
+	}
+	writeMetricFamily(w, "flecto_reload_failures", "counter", "Failed initial loads and reloads of the rules of a client.")
+	for i, state := range states {
+		_, failures := state.reloadCounts()
+		writeMetric(w, "flecto_reload_failures_total", clientLabels[i], uint64(failures))
+	}
+	writeMetricFamily(w, "flecto_rules_version", "gauge", "Version of the rules loaded by a client, 0 before the first load.")
+	for i, state := range states {
+		writeMetric(w, "flecto_rules_version", clientLabels[i], uint64(state.client.GetStateVersion()))
+	}
+	writeMetricFamily(w, "flecto_last_reload_success_timestamp_seconds", "gauge", "Time of the last successful load of a client, 0 before the first one.")
+	for i, state := range states {
+		var timestamp uint64
+		if lastSuccess := state.lastSuccessAt(); !lastSuccess.IsZero() {
+			timestamp = uint64(lastSuccess.Unix())
+		}
+		writeMetric(w, "flecto_last_reload_success_timestamp_seconds", clientLabels[i], timestamp)
+	}
+	_, _ = w.WriteString("# EOF\n")
+}
+
+func writeMetricFamily(w *bufio.Writer, name, metricType, help string) {
+	_, _ = w.WriteString("# TYPE " + name + " " + metricType + "\n# HELP " + name + " " + help + "\n")
+}
+
+func writeMetric(w *bufio.Writer, name, labels string, value uint64) {
+	_, _ = w.WriteString(name + "{" + labels + "} ")
+	_, _ = w.WriteString(strconv.FormatUint(value, 10))
+	_ = w.WriteByte('\n')
+}
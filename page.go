@@ -0,0 +1,59 @@
+package flecto_traefik_middleware
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+)
+
+// Registry of custom page content types, consulted before types.Page.HTTPContentType
+var (
+	pageContentTypes   = make(map[types.PageContentType]string)
+	pageContentTypesMu sync.RWMutex
+)
+
+// RegisterPageContentType maps a page content type to the MIME type served for it.
+// It is meant to be called at init time to support content types unknown to the manager types (CSS, JS, RSS, ...).
+// Registered types take precedence over the built-in mapping.
+func RegisterPageContentType(contentType types.PageContentType, mime string) {
+	pageContentTypesMu.Lock()
+	defer pageContentTypesMu.Unlock()
+	pageContentTypes[contentType] = mime
+}
+
+// pageContentType returns the MIME type of a page, using the registry first.
+// fallback, when set, replaces text/plain for pages with an empty or unknown content type.
+func pageContentType(p *types.Page, fallback string) string {
+	pageContentTypesMu.RLock()
+	mime, ok := pageContentTypes[p.ContentType]
+	pageContentTypesMu.RUnlock()
+	if ok {
+		return mime
+	}
+	if fallback != "" && p.ContentType != types.PageContentTypeTextPlain && p.ContentType != types.PageContentTypeXML {
+		return fallback
+	}
+	return p.HTTPContentType()
+}
+
+// withStaleWhileRevalidate appends a stale-while-revalidate directive to cacheControl.
+// Directives already allowing or forbidding stale responses are left as is.
+func withStaleWhileRevalidate(cacheControl string, d time.Duration) string {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name := strings.TrimSpace(directive)
+		if i := strings.IndexByte(name, '='); i != -1 {
+			name = name[:i]
+		}
+		if strings.EqualFold(name, "stale-while-revalidate") || strings.EqualFold(name, "no-store") {
+			return cacheControl
+		}
+	}
+	directive := "stale-while-revalidate=" + strconv.Itoa(int(d.Seconds()))
+	if strings.TrimSpace(cacheControl) == "" {
+		return directive
+	}
+	return cacheControl + ", " + directive
+}
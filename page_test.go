@@ -0,0 +1,370 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+// unregisterPageContentType removes a custom content type registered by a test.
+func unregisterPageContentType(contentType types.PageContentType) {
+	pageContentTypesMu.Lock()
+	defer pageContentTypesMu.Unlock()
+	delete(pageContentTypes, contentType)
+}
+
+func TestPageContentType(t *testing.T) {
+	const contentTypeCSS types.PageContentType = "CSS"
+
+	RegisterPageContentType(contentTypeCSS, "text/css")
+	defer unregisterPageContentType(contentTypeCSS)
+
+	t.Run("registered type uses registered mime", func(t *testing.T) {
+		assert.Equal(t, "text/css", pageContentType(&types.Page{ContentType: contentTypeCSS}, ""))
+	})
+
+	t.Run("built-in type falls back to default mapping", func(t *testing.T) {
+		assert.Equal(t, "application/xml", pageContentType(&types.Page{ContentType: types.PageContentTypeXML}, ""))
+	})
+
+	t.Run("unknown type falls back to text/plain", func(t *testing.T) {
+		assert.Equal(t, "text/plain", pageContentType(&types.Page{ContentType: "RSS"}, ""))
+	})
+
+	t.Run("registry overrides built-in type", func(t *testing.T) {
+		RegisterPageContentType(types.PageContentTypeXML, "text/xml")
+		defer unregisterPageContentType(types.PageContentTypeXML)
+
+		assert.Equal(t, "text/xml", pageContentType(&types.Page{ContentType: types.PageContentTypeXML}, ""))
+	})
+
+	t.Run("configured default replaces text/plain for empty and unknown types", func(t *testing.T) {
+		assert.Equal(t, "text/html", pageContentType(&types.Page{}, "text/html"))
+		assert.Equal(t, "text/html", pageContentType(&types.Page{ContentType: "RSS"}, "text/html"))
+	})
+
+	t.Run("configured default does not apply to known types", func(t *testing.T) {
+		assert.Equal(t, "text/plain", pageContentType(&types.Page{ContentType: types.PageContentTypeTextPlain}, "text/html"))
+		assert.Equal(t, "application/xml", pageContentType(&types.Page{ContentType: types.PageContentTypeXML}, "text/html"))
+		assert.Equal(t, "text/css", pageContentType(&types.Page{ContentType: contentTypeCSS}, "text/html"))
+	})
+}
+
+func TestMiddleware_ServeHTTP_RegisteredPageContentType(t *testing.T) {
+	const contentTypeJS types.PageContentType = "JS"
+
+	RegisterPageContentType(contentTypeJS, "text/javascript")
+	defer unregisterPageContentType(contentTypeJS)
+
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			return &types.Page{
+				Type:        types.PageTypeBasic,
+				Path:        "/app.js",
+				Content:     "console.log('flecto');",
+				ContentType: contentTypeJS,
+			}
+		},
+	}
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		defaultClient: mock,
+		hostClients:   make(map[string]client.Client),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/app.js", nil)
+	rec := httptest.NewRecorder()
+
+	m.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/javascript", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "console.log('flecto');", rec.Body.String())
+}
+
+func TestMiddleware_ServeHTTP_DefaultPageContentType(t *testing.T) {
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			return &types.Page{Type: types.PageTypeBasic, Path: "/landing", Content: "<h1>flecto</h1>"}
+		},
+	}
+	m := &Middleware{
+		name:                   "test",
+		next:                   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient:          mock,
+		hostClients:            make(map[string]client.Client),
+		defaultPageContentType: "text/html; charset=utf-8",
+	}
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/landing", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "text/html; charset=utf-8", rec.Header().Get("Content-Type"))
+}
+
+func TestMiddleware_ServeHTTP_PageOptions(t *testing.T) {
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			return &types.Page{Type: types.PageTypeBasic, Path: "/api/config.json", Content: "{}"}
+		},
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		wantNextCalled bool
+	}{
+		{name: "preflight passes through", method: http.MethodOptions, wantNextCalled: true},
+		{name: "get serves the page", method: http.MethodGet, wantNextCalled: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nextCalled := false
+			m := &Middleware{
+				name: "test",
+				next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					nextCalled = true
+					w.WriteHeader(http.StatusNoContent)
+				}),
+				defaultClient: mock,
+				hostClients:   make(map[string]client.Client),
+			}
+
+			req := httptest.NewRequest(tt.method, "http://example.com/api/config.json", nil)
+			req.Header.Set("Origin", "https://app.example.com")
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantNextCalled, nextCalled)
+			if tt.wantNextCalled {
+				assert.Equal(t, http.StatusNoContent, rec.Code)
+				assert.Empty(t, rec.Body.String())
+			} else {
+				assert.Equal(t, "{}", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestWithStaleWhileRevalidate(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl string
+		want         string
+	}{
+		{name: "empty", cacheControl: "", want: "stale-while-revalidate=300"},
+		{name: "appended to max-age", cacheControl: "max-age=60", want: "max-age=60, stale-while-revalidate=300"},
+		{name: "existing directive kept", cacheControl: "max-age=60, stale-while-revalidate=30", want: "max-age=60, stale-while-revalidate=30"},
+		{name: "no-store kept", cacheControl: "no-store", want: "no-store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, withStaleWhileRevalidate(tt.cacheControl, 5*time.Minute))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_StaleWhileRevalidate(t *testing.T) {
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			return &types.Page{Type: types.PageTypeBasic, Path: "/robots.txt", Content: "User-agent: *"}
+		},
+	}
+	m := &Middleware{
+		name:                 "test",
+		next:                 http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient:        mock,
+		hostClients:          make(map[string]client.Client),
+		staleWhileRevalidate: 5 * time.Minute,
+	}
+
+	rec := httptest.NewRecorder()
+	rec.Header().Set("Cache-Control", "max-age=60")
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil))
+
+	assert.Equal(t, "max-age=60, stale-while-revalidate=300", rec.Header().Get("Cache-Control"))
+
+	// The Cache-Control of the host response_headers is kept
+	m.hostPolicies = map[string]*hostPolicy{"example.fr": {responseHeaders: http.Header{"Cache-Control": {"max-age=60"}}}}
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.fr/robots.txt", nil))
+
+	assert.Equal(t, "max-age=60, stale-while-revalidate=300", rec.Header().Get("Cache-Control"))
+}
+
+func TestMiddleware_ServeHTTP_RuleTrailer(t *testing.T) {
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			return &types.Page{
+				Type:        types.PageTypeBasic,
+				Path:        "/robots.txt",
+				Content:     "User-agent: *",
+				ContentType: types.PageContentTypeTextPlain,
+			}
+		},
+	}
+	newMiddleware := func(debug, debugTrailer bool) *Middleware {
+		return &Middleware{
+			name: "test",
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}),
+			debug:         debug,
+			debugTrailer:  debugTrailer,
+			defaultClient: mock,
+			hostClients:   make(map[string]client.Client),
+		}
+	}
+
+	t.Run("sets rule trailer for page response", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil)
+		rec := httptest.NewRecorder()
+
+		newMiddleware(true, true).ServeHTTP(rec, req)
+
+		res := rec.Result()
+		assert.Equal(t, "User-agent: *", rec.Body.String())
+		assert.Equal(t, ruleTrailer, res.Header.Get("Trailer"))
+		assert.Equal(t, "/robots.txt", res.Trailer.Get(ruleTrailer))
+	})
+
+	t.Run("no trailer without debug", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil)
+		rec := httptest.NewRecorder()
+
+		newMiddleware(false, true).ServeHTTP(rec, req)
+
+		res := rec.Result()
+		assert.Empty(t, res.Header.Get("Trailer"))
+		assert.Empty(t, res.Trailer.Get(ruleTrailer))
+	})
+
+	t.Run("no trailer for HTTP/1.0 clients", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/robots.txt", nil)
+		req.Proto, req.ProtoMajor, req.ProtoMinor = "HTTP/1.0", 1, 0
+		rec := httptest.NewRecorder()
+
+		newMiddleware(true, true).ServeHTTP(rec, req)
+
+		assert.Empty(t, rec.Result().Header.Get("Trailer"))
+	})
+}
+
+func TestMiddleware_ServeHTTP_PageRange(t *testing.T) {
+	const sitemap = `<?xml version="1.0" encoding="UTF-8"?><urlset></urlset>`
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			return &types.Page{Type: types.PageTypeBasic, Path: "/sitemap.xml", Content: sitemap, ContentType: types.PageContentTypeXML}
+		},
+	}
+	m := &Middleware{
+		next:          http.NotFoundHandler(),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{},
+	}
+	get := func(rangeHeader string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/sitemap.xml", nil)
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("valid range", func(t *testing.T) {
+		rec := get("bytes=0-4")
+
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "<?xml", rec.Body.String())
+		assert.Equal(t, "bytes 0-4/55", rec.Header().Get("Content-Range"))
+		assert.Equal(t, "5", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("unsatisfiable range", func(t *testing.T) {
+		rec := get("bytes=100-200")
+
+		assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rec.Code)
+		assert.Equal(t, "bytes */55", rec.Header().Get("Content-Range"))
+	})
+
+	t.Run("full body", func(t *testing.T) {
+		rec := get("")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, sitemap, rec.Body.String())
+		assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+		assert.Equal(t, "55", rec.Header().Get("Content-Length"))
+		assert.Equal(t, "application/xml", rec.Header().Get("Content-Type"))
+	})
+
+	t.Run("range ignored with the rule trailer", func(t *testing.T) {
+		m.debug, m.debugTrailer = true, true
+		defer func() { m.debug, m.debugTrailer = false, false }()
+		rec := get("bytes=0-4")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, sitemap, rec.Body.String())
+	})
+
+	t.Run("range of a chunked page", func(t *testing.T) {
+		m.chunkedPageThreshold = 10
+		defer func() { m.chunkedPageThreshold = 0 }()
+		rec := get("bytes=-9")
+
+		assert.Equal(t, http.StatusPartialContent, rec.Code)
+		assert.Equal(t, "</urlset>", rec.Body.String())
+	})
+}
+
+func TestMiddleware_ServeHTTP_SkipEmptyPages(t *testing.T) {
+	mock := &mockClient{
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri == "/empty" {
+				return &types.Page{Type: types.PageTypeBasic, Path: uri, Content: ""}
+			}
+			return nil
+		},
+	}
+	newMiddleware := func(skipEmptyPages bool) *Middleware {
+		return &Middleware{
+			name: "test",
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusTeapot)
+			}),
+			defaultClient:  mock,
+			hostClients:    map[string]client.Client{},
+			debug:          true,
+			skipEmptyPages: skipEmptyPages,
+		}
+	}
+
+	t.Run("empty page is served by default", func(t *testing.T) {
+		captureLogs(t)
+		rec := httptest.NewRecorder()
+		newMiddleware(false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/empty", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Zero(t, rec.Body.Len())
+	})
+
+	t.Run("empty page is skipped", func(t *testing.T) {
+		logs := captureLogs(t)
+		rec := httptest.NewRecorder()
+		newMiddleware(true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/empty", nil))
+
+		assert.Equal(t, http.StatusTeapot, rec.Code)
+		assert.Contains(t, logs.String(), "test: Skipping empty page /empty for example.com/empty\n")
+	})
+}
@@ -0,0 +1,47 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+)
+
+// ReadyHandler returns a readiness probe: 200 once the clients have loaded rules, 503 with the keys of those
+// still without rules otherwise. Every client is waited for, or only those serving ready_hosts when set.
+// Unlike GET /health of the admin endpoint, clients still loading their first rules are not ready.
+func (m *Middleware) ReadyHandler() http.Handler {
+	return http.HandlerFunc(m.serveReady)
+}
+
+func (m *Middleware) serveReady(rw http.ResponseWriter, req *http.Request) {
+	pending := []string{}
+	for _, state := range m.readyStates() {
+		if state.client.GetStateVersion() == 0 {
+			pending = append(pending, state.redactedKey)
+		}
+	}
+	status := http.StatusOK
+	if len(pending) > 0 {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(rw, status, pending)
+}
+
+// readyStates returns the states of the clients gating readiness, ordered by settings key.
+func (m *Middleware) readyStates() []*clientState {
+	states := m.sortedStates()
+	if len(m.readyHosts) == 0 {
+		return states
+	}
+	gating := make(map[*clientState]bool, len(m.readyHosts))
+	for _, host := range m.readyHosts {
+		if state := m.clientState(m.clientForHost(host)); state != nil {
+			gating[state] = true
+		}
+	}
+	filtered := states[:0]
+	for _, state := range states {
+		if gating[state] {
+			filtered = append(filtered, state)
+		}
+	}
+	return filtered
+}
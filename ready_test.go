@@ -0,0 +1,60 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ReadyHandler(t *testing.T) {
+	loaded := &mockClient{stateVersion: 3}
+	cold := &mockClient{}
+	probe := func(m *Middleware) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.ReadyHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		return rec
+	}
+
+	t.Run("all clients loaded", func(t *testing.T) {
+		m := newAdminMiddleware(map[string]*mockClient{"a": loaded, "b": {stateVersion: 1}})
+		rec := probe(m)
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.JSONEq(t, `[]`, rec.Body.String())
+	})
+
+	t.Run("one client cold", func(t *testing.T) {
+		m := newAdminMiddleware(map[string]*mockClient{"a": loaded, "b": cold})
+		rec := probe(m)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `["b"]`, rec.Body.String())
+	})
+
+	t.Run("ready_hosts only waits for their clients", func(t *testing.T) {
+		m := newAdminMiddleware(map[string]*mockClient{"a": loaded, "b": cold})
+		m.hostClients = map[string]client.Client{"example.com": loaded, "example.fr": cold}
+		m.readyHosts = []string{"example.com"}
+		assert.Equal(t, http.StatusOK, probe(m).Code)
+
+		m.readyHosts = []string{"example.com", "example.fr:443"}
+		rec := probe(m)
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `["b"]`, rec.Body.String())
+	})
+
+	t.Run("ready_hosts fall back to the default client", func(t *testing.T) {
+		m := newAdminMiddleware(map[string]*mockClient{"a": loaded, "default": cold})
+		m.hostClients = map[string]client.Client{"example.com": loaded}
+		m.defaultClient = cold
+		m.readyHosts = []string{"other.com"}
+		rec := probe(m)
+
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.JSONEq(t, `["default"]`, rec.Body.String())
+	})
+}
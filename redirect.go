@@ -0,0 +1,164 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/flectolab/flecto-manager/common/types"
+)
+
+// RedirectStatusSeeOther redirects with a 303, the client follows the target with a GET.
+// It is not part of the manager statuses yet and is handled by the middleware.
+const RedirectStatusSeeOther types.RedirectStatus = "SEE_OTHER"
+
+// RedirectStatusUnavailableLegal answers with a 451 instead of redirecting.
+// The target, when set, identifies the entity blocking the content and is sent as a blocked-by Link.
+const RedirectStatusUnavailableLegal types.RedirectStatus = "UNAVAILABLE_FOR_LEGAL_REASONS"
+
+// redirectCode returns the HTTP status code of a redirect.
+// Unrecognized statuses use fallback, or 302 when no fallback is configured.
+func redirectCode(r *types.Redirect, fallback int) int {
+	switch r.Status {
+	case types.RedirectStatusMovedPermanent, types.RedirectStatusFound, types.RedirectStatusTemporary, types.RedirectStatusPermanent:
+		return r.HTTPCode()
+	case RedirectStatusSeeOther:
+		return http.StatusSeeOther
+	case RedirectStatusUnavailableLegal:
+		return http.StatusUnavailableForLegalReasons
+	}
+	if fallback == 0 {
+		return http.StatusFound
+	}
+	return fallback
+}
+
+// isRedirectCode reports whether code can be used to redirect a request.
+func isRedirectCode(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// isSelfRedirect reports whether target points back to the request on host, whose uri is the matched uri of u.
+// Absolute targets are compared by scheme and host too. Fragments are ignored, clients do not send them.
+func isSelfRedirect(target, scheme, host, uri string, u *url.URL) bool {
+	if i := strings.IndexByte(target, '#'); i != -1 {
+		target = target[:i]
+	}
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		t, err := url.Parse(target)
+		if err != nil || t.Host == "" {
+			return false
+		}
+		if t.Scheme != "" && !strings.EqualFold(t.Scheme, scheme) || !strings.EqualFold(t.Host, host) {
+			return false
+		}
+		target = t.RequestURI()
+	}
+	return target == uri || target == u.RequestURI()
+}
+
+// methodQualified reports whether the source of a rule without host starts with a method,
+// as in `DELETE /api/item` or `^(GET|HEAD) /api/(.*)`. Only those rules apply to the method prefixed uri,
+// the captures of other rules would include the method.
+func methodQualified(r *types.Redirect) bool {
+	if r.Type != types.RedirectTypeBasic && r.Type != types.RedirectTypeRegex {
+		return false
+	}
+	source := strings.TrimPrefix(r.Source, "^")
+	i := strings.IndexByte(source, ' ')
+	if i <= 0 {
+		return false
+	}
+	letters := false
+	for _, c := range source[:i] {
+		switch {
+		case c >= 'A' && c <= 'Z':
+			letters = true
+		case !strings.ContainsRune("()|?:", c):
+			return false
+		}
+	}
+	return letters
+}
+
+// schemeQualified reports whether the source of a host rule starts with a scheme,
+// as in `http://example.com/path` or `^https?://(.*)\.example\.com/(.*)`. Only those rules apply to the scheme
+// prefixed host, the captures of other rules would include the scheme.
+func schemeQualified(r *types.Redirect) bool {
+	if r.Type != types.RedirectTypeBasicHost && r.Type != types.RedirectTypeRegexHost {
+		return false
+	}
+	source := strings.TrimPrefix(strings.TrimPrefix(r.Source, "^"), "(?:")
+	i := strings.IndexByte(source, ':')
+	if i <= 0 || !strings.HasPrefix(source[i:], "://") && !strings.HasPrefix(source[i:], `:\/\/`) {
+		return false
+	}
+	for _, c := range source[:i] {
+		if !strings.ContainsRune("htps?|()", c) {
+			return false
+		}
+	}
+	return strings.Contains(source[:i], "http")
+}
+
+// appendQuery adds the request query to a redirect target, before any fragment.
+func appendQuery(target, rawQuery string) string {
+	if rawQuery == "" {
+		return target
+	}
+	fragment := ""
+	if i := strings.IndexByte(target, '#'); i != -1 {
+		target, fragment = target[:i], target[i:]
+	}
+	sep := "?"
+	if strings.IndexByte(target, '?') != -1 {
+		sep = "&"
+	}
+	return target + sep + rawQuery + fragment
+}
+
+// encodeRedirectTarget re-encodes a redirect target so that the Location header
+// only contains valid URL characters (spaces, unicode, ...).
+// The raw target is returned when it cannot be parsed.
+func encodeRedirectTarget(target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	u.RawQuery = escapeUnsafe(u.RawQuery)
+	return u.String()
+}
+
+// escapeUnsafe percent-encodes control, space and non-ASCII bytes, leaving
+// already encoded sequences and reserved characters untouched.
+func escapeUnsafe(s string) string {
+	unsafe := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] >= 0x7f {
+			unsafe++
+		}
+	}
+	if unsafe == 0 {
+		return s
+	}
+
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	b.Grow(len(s) + 2*unsafe)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,845 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeRedirectTarget(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{
+			name:   "plain path unchanged",
+			target: "/new-path",
+			want:   "/new-path",
+		},
+		{
+			name:   "spaces in path",
+			target: "/my page",
+			want:   "/my%20page",
+		},
+		{
+			name:   "unicode in path",
+			target: "/café",
+			want:   "/caf%C3%A9",
+		},
+		{
+			name:   "already encoded path unchanged",
+			target: "/my%20page",
+			want:   "/my%20page",
+		},
+		{
+			name:   "absolute url with spaces in query",
+			target: "https://example.com/search?q=a b&lang=fr",
+			want:   "https://example.com/search?q=a%20b&lang=fr",
+		},
+		{
+			name:   "unicode in query",
+			target: "/search?q=été",
+			want:   "/search?q=%C3%A9t%C3%A9",
+		},
+		{
+			name:   "invalid url falls back to raw target",
+			target: "http://exa mple.com/path",
+			want:   "http://exa mple.com/path",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, encodeRedirectTarget(tt.target))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_EncodeRedirectTarget(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: "/old",
+				Target: "/nouvelle page",
+				Status: types.RedirectStatusMovedPermanent,
+			}, "/nouvelle page"
+		},
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("encodes target when enabled", func(t *testing.T) {
+		m := &Middleware{
+			name:                 "test",
+			next:                 next,
+			defaultClient:        mock,
+			hostClients:          make(map[string]client.Client),
+			encodeRedirectTarget: true,
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)
+		rec := httptest.NewRecorder()
+
+		m.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "/nouvelle%20page", rec.Header().Get("Location"))
+	})
+
+	t.Run("preserves raw target when disabled", func(t *testing.T) {
+		m := &Middleware{
+			name:          "test",
+			next:          next,
+			defaultClient: mock,
+			hostClients:   make(map[string]client.Client),
+		}
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)
+		rec := httptest.NewRecorder()
+
+		m.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "/nouvelle page", rec.Header().Get("Location"))
+	})
+}
+
+func TestRedirectCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   types.RedirectStatus
+		fallback int
+		want     int
+	}{
+		{name: "known status ignores fallback", status: types.RedirectStatusTemporary, fallback: 301, want: 307},
+		{name: "see other status", status: RedirectStatusSeeOther, fallback: 301, want: 303},
+		{name: "unavailable for legal reasons status", status: RedirectStatusUnavailableLegal, fallback: 301, want: 451},
+		{name: "unknown status uses fallback", status: "UNKNOWN", fallback: 301, want: 301},
+		{name: "empty status uses fallback", status: "", fallback: 308, want: 308},
+		{name: "unknown status without fallback uses 302", status: "UNKNOWN", fallback: 0, want: 302},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, redirectCode(&types.Redirect{Status: tt.status}, tt.fallback))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_DefaultRedirectCode(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: "/old",
+				Target: "/new",
+				Status: "GONE_FOREVER",
+			}, "/new"
+		},
+	}
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		defaultClient:       mock,
+		hostClients:         make(map[string]client.Client),
+		defaultRedirectCode: http.StatusMovedPermanently,
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/old", nil)
+	rec := httptest.NewRecorder()
+
+	m.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "/new", rec.Header().Get("Location"))
+}
+
+func TestMiddleware_ServeHTTP_RedirectRuleTrailer(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			return &types.Redirect{
+				Type:   types.RedirectTypeRegex,
+				Source: "^/old/(.*)$",
+				Target: "/new/$1",
+				Status: types.RedirectStatusFound,
+			}, "/new/page"
+		},
+	}
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		debug:         true,
+		debugTrailer:  true,
+		defaultClient: mock,
+		hostClients:   make(map[string]client.Client),
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/old/page", nil)
+	rec := httptest.NewRecorder()
+
+	m.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	assert.Equal(t, http.StatusFound, res.StatusCode)
+	assert.Equal(t, "^/old/(.*)$", res.Trailer.Get(ruleTrailer))
+}
+
+func TestAppendQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		rawQuery string
+		want     string
+	}{
+		{name: "empty query", target: "/new", rawQuery: "", want: "/new"},
+		{name: "target without query", target: "/new", rawQuery: "ref=x", want: "/new?ref=x"},
+		{name: "target with query", target: "/new?lang=fr", rawQuery: "ref=x", want: "/new?lang=fr&ref=x"},
+		{name: "target with fragment", target: "https://example.com/new#top", rawQuery: "ref=x", want: "https://example.com/new?ref=x#top"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, appendQuery(tt.target, tt.rawQuery))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchPathOnly(t *testing.T) {
+	// Only the exact path is known, as a basic rule stored without query
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri != "/old" {
+				return nil, ""
+			}
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: "/old",
+				Target: "/new",
+				Status: types.RedirectStatusMovedPermanent,
+			}, "/new"
+		},
+	}
+
+	tests := []struct {
+		name           string
+		matchPathOnly  bool
+		requestURL     string
+		wantStatusCode int
+		wantLocation   string
+	}{
+		{
+			name:           "query-bearing request matches path rule",
+			matchPathOnly:  true,
+			requestURL:     "http://example.com/old?ref=x&utm=y",
+			wantStatusCode: http.StatusMovedPermanently,
+			wantLocation:   "/new?ref=x&utm=y",
+		},
+		{
+			name:           "request without query is unchanged",
+			matchPathOnly:  true,
+			requestURL:     "http://example.com/old",
+			wantStatusCode: http.StatusMovedPermanently,
+			wantLocation:   "/new",
+		},
+		{
+			name:           "query-bearing request passes through when disabled",
+			matchPathOnly:  false,
+			requestURL:     "http://example.com/old?ref=x",
+			wantStatusCode: http.StatusOK,
+		},
+		{
+			name:           "unknown path still passes through",
+			matchPathOnly:  true,
+			requestURL:     "http://example.com/other?ref=x",
+			wantStatusCode: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{
+				name: "test",
+				next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+				defaultClient: mock,
+				hostClients:   make(map[string]client.Client),
+				matchPathOnly: tt.matchPathOnly,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.requestURL, nil)
+			rec := httptest.NewRecorder()
+
+			m.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatusCode, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_SeeOther(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			return &types.Redirect{
+				Type:   types.RedirectTypeBasic,
+				Source: "/form",
+				Target: "/thank-you",
+				Status: RedirectStatusSeeOther,
+			}, "/thank-you"
+		},
+	}
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}),
+		defaultClient: mock,
+		hostClients:   make(map[string]client.Client),
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "http://example.com/form", nil)
+	rec := httptest.NewRecorder()
+
+	m.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusSeeOther, rec.Code)
+	assert.Equal(t, "/thank-you", rec.Header().Get("Location"))
+}
+
+func TestMiddleware_ServeHTTP_UnavailableLegal(t *testing.T) {
+	tests := []struct {
+		name     string
+		target   string
+		format   string
+		wantLink string
+		wantBody string
+	}{
+		{
+			name:     "with blocking entity",
+			target:   "https://authority.example/notice",
+			wantLink: `<https://authority.example/notice>; rel="blocked-by"`,
+			wantBody: "Unavailable For Legal Reasons\n",
+		},
+		{
+			name:     "without blocking entity",
+			target:   "",
+			wantBody: "Unavailable For Legal Reasons\n",
+		},
+		{
+			name:     "json format",
+			target:   "https://authority.example/notice",
+			format:   syntheticErrorFormatJSON,
+			wantLink: `<https://authority.example/notice>; rel="blocked-by"`,
+			wantBody: `{"error":"Unavailable For Legal Reasons","status":451}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := &mockClient{
+				redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+					return &types.Redirect{
+						Type:   types.RedirectTypeBasic,
+						Source: "/removed",
+						Target: tt.target,
+						Status: RedirectStatusUnavailableLegal,
+					}, tt.target
+				},
+			}
+			m := &Middleware{
+				name: "test",
+				next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+				defaultClient:        mock,
+				hostClients:          make(map[string]client.Client),
+				syntheticErrorFormat: tt.format,
+			}
+
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/removed", nil))
+
+			assert.Equal(t, http.StatusUnavailableForLegalReasons, rec.Code)
+			assert.Empty(t, rec.Header().Get("Location"))
+			assert.Equal(t, tt.wantLink, rec.Header().Get("Link"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchMode(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			switch uri {
+			case "/café", "/a/b?x=1":
+				return &types.Redirect{Source: uri, Target: "/decoded", Status: types.RedirectStatusFound}, "/decoded"
+			case "/caf%C3%A9", "/a%2Fb?x=1":
+				return &types.Redirect{Source: uri, Target: "/raw", Status: types.RedirectStatusFound}, "/raw"
+			}
+			return nil, ""
+		},
+	}
+
+	tests := []struct {
+		name         string
+		matchDecoded bool
+		requestURL   string
+		wantLocation string
+	}{
+		{name: "raw unicode", requestURL: "http://example.com/caf%C3%A9", wantLocation: "/raw"},
+		{name: "decoded unicode", matchDecoded: true, requestURL: "http://example.com/caf%C3%A9", wantLocation: "/decoded"},
+		{name: "raw encoded slash", requestURL: "http://example.com/a%2Fb?x=1", wantLocation: "/raw"},
+		{name: "decoded encoded slash", matchDecoded: true, requestURL: "http://example.com/a%2Fb?x=1", wantLocation: "/decoded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{
+				next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				defaultClient: mock,
+				hostClients:   map[string]client.Client{},
+				matchDecoded:  tt.matchDecoded,
+			}
+
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.requestURL, nil))
+
+			assert.Equal(t, http.StatusFound, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchScheme(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			switch hostname + uri {
+			case "http://example.com/path":
+				return &types.Redirect{Type: types.RedirectTypeRegexHost, Source: "^http://example.com/path$", Target: "/insecure", Status: types.RedirectStatusFound}, "/insecure"
+			case "example.com/path", "example.com/other":
+				return &types.Redirect{Type: types.RedirectTypeBasicHost, Source: "example.com" + uri, Target: "/plain", Status: types.RedirectStatusFound}, "/plain"
+			}
+			return nil, ""
+		},
+	}
+
+	tests := []struct {
+		name           string
+		matchScheme    bool
+		trustForwarded bool
+		requestURL     string
+		forwardedProto string
+		wantLocation   string
+	}{
+		{name: "http matches the scheme rule", matchScheme: true, requestURL: "http://example.com/path", wantLocation: "/insecure"},
+		{name: "https falls back to the host rule", matchScheme: true, requestURL: "https://example.com/path", wantLocation: "/plain"},
+		{name: "trusted forwarded https", matchScheme: true, trustForwarded: true, requestURL: "http://example.com/path", forwardedProto: "https", wantLocation: "/plain"},
+		{name: "rules without scheme keep working", matchScheme: true, requestURL: "http://example.com/other", wantLocation: "/plain"},
+		{name: "disabled", requestURL: "http://example.com/path", wantLocation: "/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{
+				next:                  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+				defaultClient:         mock,
+				hostClients:           map[string]client.Client{},
+				matchScheme:           tt.matchScheme,
+				trustForwardedHeaders: tt.trustForwarded,
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.requestURL, nil)
+			if tt.forwardedProto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.forwardedProto)
+			}
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusFound, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchSchemeCaptures(t *testing.T) {
+	tree := types.NewRedirectTreeMatcher()
+	for _, r := range []*types.Redirect{
+		{Type: types.RedirectTypeRegexHost, Source: `(.*)\.example\.com/legacy/(.*)`, Target: "https://$1.example.org/$2", Status: types.RedirectStatusFound},
+		{Type: types.RedirectTypeRegexHost, Source: `^http://(.*)\.example\.com/insecure/(.*)$`, Target: "https://$1.example.com/$2", Status: types.RedirectStatusMovedPermanent},
+	} {
+		assert.NoError(t, tree.Insert(r))
+	}
+	m := &Middleware{
+		next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient: &mockClient{redirectMatch: tree.Match},
+		hostClients:   map[string]client.Client{},
+		matchScheme:   true,
+	}
+
+	// The capture of a host rule without scheme does not include the scheme
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://shop.example.com/legacy/x", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "https://shop.example.org/x", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://shop.example.com/insecure/x", nil))
+	assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+	assert.Equal(t, "https://shop.example.com/x", rec.Header().Get("Location"))
+}
+
+func TestSchemeQualified(t *testing.T) {
+	tests := []struct {
+		redirect types.Redirect
+		want     bool
+	}{
+		{types.Redirect{Type: types.RedirectTypeBasicHost, Source: "http://example.com/path"}, true},
+		{types.Redirect{Type: types.RedirectTypeRegexHost, Source: "^https?://example.com/(.*)$"}, true},
+		{types.Redirect{Type: types.RedirectTypeRegexHost, Source: "^(http|https)://example.com/(.*)$"}, true},
+		{types.Redirect{Type: types.RedirectTypeRegexHost, Source: `^(?:https):\/\/example.com/(.*)$`}, true},
+		{types.Redirect{Type: types.RedirectTypeRegexHost, Source: `(.*)\.example\.com/legacy/(.*)`}, false},
+		{types.Redirect{Type: types.RedirectTypeBasicHost, Source: "httpbin.org/path"}, false},
+		{types.Redirect{Type: types.RedirectTypeBasicHost, Source: "example.com:8080/path"}, false},
+		{types.Redirect{Type: types.RedirectTypeRegex, Source: "^http://example.com/(.*)$"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.redirect.Source, func(t *testing.T) {
+			assert.Equal(t, tt.want, schemeQualified(&tt.redirect))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchMethod(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			switch uri {
+			case "DELETE /api/item":
+				return &types.Redirect{Type: types.RedirectTypeBasic, Source: "DELETE /api/item", Target: "/api/v2/item", Status: types.RedirectStatusTemporary}, "/api/v2/item"
+			case "/old":
+				return &types.Redirect{Type: types.RedirectTypeBasic, Source: "/old", Target: "/new", Status: types.RedirectStatusTemporary}, "/new"
+			}
+			return nil, ""
+		},
+	}
+
+	tests := []struct {
+		name         string
+		matchMethod  bool
+		method       string
+		requestURL   string
+		wantCode     int
+		wantLocation string
+	}{
+		{name: "method rule applies to its method", matchMethod: true, method: http.MethodDelete, requestURL: "http://example.com/api/item", wantCode: http.StatusTemporaryRedirect, wantLocation: "/api/v2/item"},
+		{name: "method rule ignores other methods", matchMethod: true, method: http.MethodGet, requestURL: "http://example.com/api/item", wantCode: http.StatusOK},
+		{name: "method agnostic rule keeps working", matchMethod: true, method: http.MethodDelete, requestURL: "http://example.com/old", wantCode: http.StatusTemporaryRedirect, wantLocation: "/new"},
+		{name: "disabled", method: http.MethodDelete, requestURL: "http://example.com/api/item", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{
+				next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusOK)
+				}),
+				defaultClient: mock,
+				hostClients:   map[string]client.Client{},
+				matchMethod:   tt.matchMethod,
+			}
+
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.requestURL, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, tt.wantLocation, rec.Header().Get("Location"))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchMethodCaptures(t *testing.T) {
+	tree := types.NewRedirectTreeMatcher()
+	for _, r := range []*types.Redirect{
+		{Type: types.RedirectTypeRegex, Source: `(.*)\.html$`, Target: "$1", Status: types.RedirectStatusFound},
+		{Type: types.RedirectTypeRegex, Source: `^(DELETE|PUT) /api/v1/(.*)$`, Target: "/api/v2/$2", Status: types.RedirectStatusTemporary},
+	} {
+		assert.NoError(t, tree.Insert(r))
+	}
+	m := &Middleware{
+		next:          http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		defaultClient: &mockClient{redirectMatch: tree.Match},
+		hostClients:   map[string]client.Client{},
+		matchMethod:   true,
+	}
+
+	// The capture of a method agnostic rule does not include the method
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/page.html", nil))
+	assert.Equal(t, http.StatusFound, rec.Code)
+	assert.Equal(t, "/page", rec.Header().Get("Location"))
+
+	rec = httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "http://example.com/api/v1/item", nil))
+	assert.Equal(t, http.StatusTemporaryRedirect, rec.Code)
+	assert.Equal(t, "/api/v2/item", rec.Header().Get("Location"))
+}
+
+func TestMethodQualified(t *testing.T) {
+	tests := []struct {
+		redirect types.Redirect
+		want     bool
+	}{
+		{types.Redirect{Type: types.RedirectTypeBasic, Source: "DELETE /api/item"}, true},
+		{types.Redirect{Type: types.RedirectTypeRegex, Source: "^(GET|HEAD) /api/(.*)$"}, true},
+		{types.Redirect{Type: types.RedirectTypeRegex, Source: "^(?:GET|HEAD) /api/(.*)$"}, true},
+		{types.Redirect{Type: types.RedirectTypeBasic, Source: "/old"}, false},
+		{types.Redirect{Type: types.RedirectTypeRegex, Source: `(.*)\.html$`}, false},
+		{types.Redirect{Type: types.RedirectTypeRegex, Source: "^/search (.*)$"}, false},
+		{types.Redirect{Type: types.RedirectTypeBasicHost, Source: "GET example.com/path"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.redirect.Source, func(t *testing.T) {
+			assert.Equal(t, tt.want, methodQualified(&tt.redirect))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MatchOrder(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri != "/both" && uri != "/redirect-only" {
+				return nil, ""
+			}
+			return &types.Redirect{Source: uri, Target: "/new", Status: types.RedirectStatusFound}, "/new"
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/both" && uri != "/page-only" {
+				return nil
+			}
+			return &types.Page{Type: types.PageTypeBasic, Path: uri, Content: "page"}
+		},
+	}
+
+	tests := []struct {
+		name      string
+		pageFirst bool
+		path      string
+		wantCode  int
+	}{
+		{name: "redirect wins by default", path: "/both", wantCode: http.StatusFound},
+		{name: "page wins in page-first", pageFirst: true, path: "/both", wantCode: http.StatusOK},
+		{name: "redirect still applies in page-first", pageFirst: true, path: "/redirect-only", wantCode: http.StatusFound},
+		{name: "page still applies by default", path: "/page-only", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Middleware{
+				next:          http.NotFoundHandler(),
+				defaultClient: mock,
+				hostClients:   map[string]client.Client{},
+				pageFirst:     tt.pageFirst,
+			}
+
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantCode == http.StatusOK {
+				assert.Equal(t, "page", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_ConflictPolicy(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri != "/both" && uri != "/redirect-only" {
+				return nil, ""
+			}
+			return &types.Redirect{Source: uri, Target: "/new", Status: types.RedirectStatusFound}, "/new"
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri != "/both" && uri != "/page-only" {
+				return nil
+			}
+			return &types.Page{Type: types.PageTypeBasic, Path: uri, Content: "page"}
+		},
+	}
+	newMiddleware := func(policy string) *Middleware {
+		handler, err := New(context.Background(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}), &Config{
+			ClientSettings: ClientSettings{
+				ManagerUrl:    "http://localhost:8080",
+				NamespaceCode: "ns",
+				ProjectCode:   "proj",
+				TokenJWT:      "token",
+			},
+			ConflictPolicy: policy,
+		}, "test")
+		assert.NoError(t, err)
+		return handler.(*Middleware)
+	}
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+	clientFactory = func(cfg *client.Config) client.Client {
+		return mock
+	}
+
+	tests := []struct {
+		name     string
+		policy   string
+		path     string
+		wantCode int
+		wantLog  bool
+	}{
+		{name: "redirect wins by default", path: "/both", wantCode: http.StatusFound},
+		{name: "redirect-wins", policy: conflictPolicyRedirectWins, path: "/both", wantCode: http.StatusFound},
+		{name: "page-wins", policy: conflictPolicyPageWins, path: "/both", wantCode: http.StatusOK},
+		{name: "error passes through", policy: conflictPolicyError, path: "/both", wantCode: http.StatusTeapot, wantLog: true},
+		{name: "error still serves redirects", policy: conflictPolicyError, path: "/redirect-only", wantCode: http.StatusFound},
+		{name: "error still serves pages", policy: conflictPolicyError, path: "/page-only", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMiddleware(tt.policy)
+			logs := captureLogs(t)
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+tt.path, nil))
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			if tt.wantLog {
+				assert.Equal(t, "test: Conflicting rules for example.com/both: redirect /both and page /both both match, passing through\n", logs.String())
+			} else {
+				assert.Empty(t, logs.String())
+			}
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_EmptyRedirectTarget(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			switch uri {
+			case "/empty", "/both":
+				return &types.Redirect{Source: uri, Target: "", Status: types.RedirectStatusMovedPermanent}, ""
+			case "/legal":
+				return &types.Redirect{Source: uri, Target: "", Status: RedirectStatusUnavailableLegal}, ""
+			}
+			return nil, ""
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri == "/both" {
+				return &types.Page{Type: types.PageTypeBasic, Path: uri, Content: "page"}
+			}
+			return nil
+		},
+	}
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{},
+		debug:         true,
+	}
+	serve := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil))
+		return rec
+	}
+
+	t.Run("empty target passes through", func(t *testing.T) {
+		logs := captureLogs(t)
+		rec := serve("/empty")
+
+		assert.Equal(t, http.StatusTeapot, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+		assert.Contains(t, logs.String(), "test: Skipping redirect /empty with an empty target for example.com/empty\n")
+	})
+
+	t.Run("empty target falls back to the page", func(t *testing.T) {
+		captureLogs(t)
+		rec := serve("/both")
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "page", rec.Body.String())
+	})
+
+	t.Run("451 does not need a target", func(t *testing.T) {
+		captureLogs(t)
+		rec := serve("/legal")
+
+		assert.Equal(t, http.StatusUnavailableForLegalReasons, rec.Code)
+		assert.Empty(t, rec.Header().Get("Link"))
+	})
+
+	t.Run("empty target with match_path_only", func(t *testing.T) {
+		captureLogs(t)
+		m.matchPathOnly = true
+		defer func() { m.matchPathOnly = false }()
+		rec := serve("/empty?utm_source=mail")
+
+		assert.Equal(t, http.StatusTeapot, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+	})
+}
+
+func TestIsSelfRedirect(t *testing.T) {
+	u := &url.URL{Path: "/promo", RawQuery: "utm=1"}
+	tests := []struct {
+		name   string
+		target string
+		want   bool
+	}{
+		{name: "same uri", target: "/promo?utm=1", want: true},
+		{name: "same uri with fragment", target: "/promo?utm=1#top", want: true},
+		{name: "absolute same host", target: "https://example.com/promo?utm=1", want: true},
+		{name: "scheme relative same host", target: "//EXAMPLE.com/promo?utm=1", want: true},
+		{name: "other path", target: "/promo/", want: false},
+		{name: "other query", target: "/promo", want: false},
+		{name: "other scheme", target: "http://example.com/promo?utm=1", want: false},
+		{name: "other host", target: "https://www.example.com/promo?utm=1", want: false},
+		{name: "relative path", target: "promo?utm=1", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isSelfRedirect(tt.target, "https", "example.com", "/promo?utm=1", u))
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_SelfRedirect(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			// An over-broad regex rule resolving to the request itself
+			return &types.Redirect{Type: types.RedirectTypeRegex, Source: "^/(.*)$", Target: "/$1", Status: types.RedirectStatusMovedPermanent}, uri
+		},
+	}
+	m := &Middleware{
+		name: "test",
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{},
+		debug:         true,
+	}
+	logs := captureLogs(t)
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/promo", nil))
+
+	assert.Equal(t, http.StatusTeapot, rec.Code)
+	assert.Empty(t, rec.Header().Get("Location"))
+	assert.Contains(t, logs.String(), "test: Skipping redirect ^/(.*)$ to the request itself for example.com/promo\n")
+}
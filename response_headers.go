@@ -0,0 +1,47 @@
+package flecto_traefik_middleware
+
+import "net/http"
+
+// responseHeaderWriter adds the response_headers of a host config to the response when its status is written.
+// Headers already set by the response, from the next handler or the middleware, are kept.
+type responseHeaderWriter struct {
+	http.ResponseWriter
+	headers     http.Header
+	wroteHeader bool
+}
+
+func (w *responseHeaderWriter) WriteHeader(code int) {
+	// Informational responses precede the final one, which gets the headers
+	if !w.wroteHeader && code >= http.StatusOK {
+		w.wroteHeader = true
+		h := w.ResponseWriter.Header()
+		for name, values := range w.headers {
+			if _, exists := h[name]; !exists {
+				// Copied so the response cannot append to the values shared by every request
+				h[name] = append([]string(nil), values...)
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseHeaderWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *responseHeaderWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController.
+func (w *responseHeaderWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
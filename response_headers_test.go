@@ -0,0 +1,103 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_ResponseHeaders(t *testing.T) {
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri == "/old" {
+				return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+			}
+			return nil, ""
+		},
+		pageMatch: func(hostname, uri string) *types.Page {
+			if uri == "/robots.txt" {
+				return &types.Page{Type: types.PageTypeBasic, Path: "/robots.txt", Content: "User-agent: *"}
+			}
+			return nil
+		},
+	}
+	policy, err := newHostPolicy(HostConfig{
+		ResponseHeaders: map[string]string{"x-powered-by": "flecto", "Strict-Transport-Security": "max-age=63072000"},
+	})
+	assert.NoError(t, err)
+	m := &Middleware{
+		next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/backend-header" {
+				w.Header().Set("X-Powered-By", "backend")
+				w.Header().Add("Strict-Transport-Security", "max-age=60")
+			}
+			w.Header().Add("X-Backend", "1")
+			_, _ = w.Write([]byte("backend"))
+		}),
+		defaultClient: mock,
+		hostClients:   map[string]client.Client{"example.com": mock},
+		hostPolicies:  map[string]*hostPolicy{"example.com": policy},
+	}
+	serve := func(url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantCode int
+	}{
+		{name: "passthrough", path: "/other", wantCode: http.StatusOK},
+		{name: "redirect", path: "/old", wantCode: http.StatusMovedPermanently},
+		{name: "page", path: "/robots.txt", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve("http://example.com" + tt.path)
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, []string{"flecto"}, rec.Header().Values("X-Powered-By"))
+			assert.Equal(t, []string{"max-age=63072000"}, rec.Header().Values("Strict-Transport-Security"))
+		})
+	}
+
+	t.Run("backend headers take precedence", func(t *testing.T) {
+		rec := serve("http://example.com/backend-header")
+
+		assert.Equal(t, []string{"backend"}, rec.Header().Values("X-Powered-By"))
+		assert.Equal(t, []string{"max-age=60"}, rec.Header().Values("Strict-Transport-Security"))
+		assert.Equal(t, "backend", rec.Body.String())
+	})
+
+	t.Run("other hosts are untouched", func(t *testing.T) {
+		rec := serve("http://other.com/other")
+
+		assert.Empty(t, rec.Header().Get("X-Powered-By"))
+		assert.Equal(t, "1", rec.Header().Get("X-Backend"))
+	})
+
+	t.Run("configured values are not shared with the response", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		w := &responseHeaderWriter{ResponseWriter: rec, headers: policy.responseHeaders}
+		w.WriteHeader(http.StatusOK)
+		rec.Header()["X-Powered-By"][0] = "changed"
+
+		assert.Equal(t, "flecto", policy.responseHeaders.Get("X-Powered-By"))
+	})
+}
+
+func TestValidateHostPolicy_ResponseHeaders(t *testing.T) {
+	err := validateHostPolicy(1, HostConfig{ResponseHeaders: map[string]string{"X Powered By": "flecto"}})
+	assert.EqualError(t, err, `host_configs[1]: response_headers "X Powered By" is not a valid header`)
+
+	err = validateHostPolicy(1, HostConfig{ResponseHeaders: map[string]string{"X-Powered-By": "flecto\r\nSet-Cookie: a=b"}})
+	assert.EqualError(t, err, `host_configs[1]: response_headers "X-Powered-By" is not a valid header`)
+
+	assert.NoError(t, validateHostPolicy(1, HostConfig{ResponseHeaders: map[string]string{"X-Powered-By": "flecto"}}))
+}
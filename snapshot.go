@@ -0,0 +1,30 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+)
+
+// maxSnapshotAttempts bounds the matchings of a request while reloads keep swapping the rules of its client
+const maxSnapshotAttempts = 3
+
+// matchRules matches the rules of c like matchRulesOrdered, against a single version of them.
+// A reload swaps the redirects and the pages of the client together, but each match reads the current ones,
+// so the matching is retried when the version changed in between: a request never mixes new redirects with old pages.
+// When the version still changes after maxSnapshotAttempts, no rule matches and the request is passed through.
+func (m *Middleware) matchRules(c client.Client, policy *hostPolicy, req *http.Request, uri string, u *url.URL) (*types.Redirect, string, *types.Page) {
+	for attempt := 1; attempt <= maxSnapshotAttempts; attempt++ {
+		version := c.GetStateVersion()
+		redirect, target, page := m.matchRulesOrdered(c, policy, req, uri, u)
+		if c.GetStateVersion() == version {
+			return redirect, target, page
+		}
+	}
+	if m.debug {
+		m.logf("Rules of %s%s changed during %d matchings, passing through", req.Host, uri, maxSnapshotAttempts)
+	}
+	return nil, "", nil
+}
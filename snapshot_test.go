@@ -0,0 +1,142 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// rulesVersion is a version of the rules of swappingClient: odd versions redirect /old, even versions serve it as a page.
+type rulesVersion struct {
+	version int
+}
+
+// swappingClient swaps its redirects and pages together, like the rules of a reloaded client.
+type swappingClient struct {
+	mockClient
+	rules atomic.Value
+	// onRedirectMatch runs after each redirect match, between the redirect and the page matches of a request
+	onRedirectMatch func()
+}
+
+func newSwappingClient(version int) *swappingClient {
+	c := &swappingClient{}
+	c.rules.Store(&rulesVersion{version: version})
+	return c
+}
+
+func (c *swappingClient) swap() {
+	c.rules.Store(&rulesVersion{version: c.load().version + 1})
+}
+
+func (c *swappingClient) load() *rulesVersion {
+	return c.rules.Load().(*rulesVersion)
+}
+
+func (c *swappingClient) GetStateVersion() int {
+	return c.load().version
+}
+
+func (c *swappingClient) RedirectMatch(hostname, uri string) (*types.Redirect, string) {
+	rules := c.load()
+	if c.onRedirectMatch != nil {
+		c.onRedirectMatch()
+	}
+	if rules.version%2 == 1 && uri == "/old" {
+		return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+	}
+	return nil, ""
+}
+
+func (c *swappingClient) PageMatch(hostname, uri string) *types.Page {
+	if c.load().version%2 == 0 && uri == "/old" {
+		return &types.Page{Path: "/old", Content: "old", ContentType: types.PageContentTypeTextPlain}
+	}
+	return nil
+}
+
+func TestMiddleware_MatchRules_Snapshot(t *testing.T) {
+	t.Run("a reload between the matches rematches the new version", func(t *testing.T) {
+		c := newSwappingClient(1)
+		swapped := false
+		c.onRedirectMatch = func() {
+			if !swapped {
+				swapped = true
+				c.swap()
+			}
+		}
+		m := &Middleware{defaultClient: c}
+		// The redirect of version 1 is gone, the page of version 2 is served
+		req := httptest.NewRequest(http.MethodGet, "/old", nil)
+		redirect, _, page := m.matchRules(c, nil, req, "/old", req.URL)
+		assert.Nil(t, redirect)
+		if assert.NotNil(t, page) {
+			assert.Equal(t, "/old", page.Path)
+		}
+	})
+
+	t.Run("matching gives up rematching after max attempts", func(t *testing.T) {
+		c := newSwappingClient(2)
+		attempts := 0
+		c.onRedirectMatch = func() {
+			attempts++
+			c.swap()
+		}
+		m := &Middleware{defaultClient: c}
+		req := httptest.NewRequest(http.MethodGet, "/old", nil)
+		redirect, target, page := m.matchRules(c, nil, req, "/old", req.URL)
+		assert.Equal(t, maxSnapshotAttempts, attempts)
+		// No version could be matched alone, the request is passed through
+		assert.Nil(t, redirect)
+		assert.Empty(t, target)
+		assert.Nil(t, page)
+	})
+
+	t.Run("exhausted matching passes the request through", func(t *testing.T) {
+		c := newSwappingClient(1)
+		c.onRedirectMatch = c.swap
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusTeapot)
+		})
+		m := &Middleware{name: "test", next: next, defaultClient: c, debug: true}
+		logs := captureLogs(t)
+
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+		assert.Equal(t, http.StatusTeapot, rec.Code)
+		assert.Contains(t, logs.String(), "changed during 3 matchings, passing through")
+	})
+
+	t.Run("requests never mix two versions while rules are swapped", func(t *testing.T) {
+		c := newSwappingClient(1)
+		next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.WriteHeader(http.StatusTeapot)
+		})
+		m := &Middleware{next: next, defaultClient: c}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil {
+				c.swap()
+				time.Sleep(100 * time.Microsecond)
+			}
+		}()
+		for i := 0; i < 1000; i++ {
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/old", nil))
+			assert.Contains(t, []int{http.StatusMovedPermanently, http.StatusOK}, rec.Code)
+		}
+		cancel()
+		wg.Wait()
+	})
+}
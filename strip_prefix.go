@@ -0,0 +1,69 @@
+package flecto_traefik_middleware
+
+import (
+	"strings"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+)
+
+// prefixClient matches the rules of a client against the request uri with strip_prefix trimmed,
+// so rules are authored relative to the sub-path the middleware is mounted under.
+type prefixClient struct {
+	client.Client
+	prefix string
+	// restore re-adds prefix to the relative targets of the matched redirects
+	restore bool
+}
+
+// RedirectMatch matches uri, optionally preceded by the request method, once prefix is trimmed.
+// Requests outside of prefix never match.
+func (c *prefixClient) RedirectMatch(host, uri string) (*types.Redirect, string) {
+	stripped, ok := stripURIPrefix(uri, c.prefix)
+	if !ok {
+		return nil, ""
+	}
+	redirect, target := c.Client.RedirectMatch(host, stripped)
+	if redirect != nil && c.restore {
+		target = restoreTargetPrefix(target, c.prefix)
+	}
+	return redirect, target
+}
+
+// PageMatch matches uri once prefix is trimmed, requests outside of prefix never match.
+func (c *prefixClient) PageMatch(host, uri string) *types.Page {
+	stripped, ok := stripURIPrefix(uri, c.prefix)
+	if !ok {
+		return nil
+	}
+	return c.Client.PageMatch(host, stripped)
+}
+
+// stripURIPrefix trims prefix from the path of uri, keeping the method match_method may prepend.
+// It reports false when the path is not prefix itself or below it.
+func stripURIPrefix(uri, prefix string) (string, bool) {
+	i := strings.IndexByte(uri, '/')
+	if i == -1 {
+		return "", false
+	}
+	method, path := uri[:i], uri[i:]
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok {
+		return "", false
+	}
+	if rest == "" || rest[0] == '?' {
+		return method + "/" + rest, true
+	}
+	if rest[0] != '/' {
+		return "", false
+	}
+	return method + rest, true
+}
+
+// restoreTargetPrefix prepends prefix to a target relative to the host, absolute targets are kept.
+func restoreTargetPrefix(target, prefix string) string {
+	if !strings.HasPrefix(target, "/") || strings.HasPrefix(target, "//") {
+		return target
+	}
+	return prefix + target
+}
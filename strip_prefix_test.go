@@ -0,0 +1,122 @@
+package flecto_traefik_middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStripURIPrefix(t *testing.T) {
+	tests := []struct {
+		uri    string
+		want   string
+		wantOk bool
+	}{
+		{"/app/old", "/old", true},
+		{"/app/old?page=2", "/old?page=2", true},
+		{"/app", "/", true},
+		{"/app?page=2", "/?page=2", true},
+		{"GET /app/old", "GET /old", true},
+		{"/apple", "", false},
+		{"/other/app/old", "", false},
+		{"GET /other", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, ok := stripURIPrefix(tt.uri, "/app")
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRestoreTargetPrefix(t *testing.T) {
+	assert.Equal(t, "/app/new", restoreTargetPrefix("/new", "/app"))
+	assert.Equal(t, "/app/new?page=2", restoreTargetPrefix("/new?page=2", "/app"))
+	assert.Equal(t, "https://example.com/new", restoreTargetPrefix("https://example.com/new", "/app"))
+	assert.Equal(t, "//example.com/new", restoreTargetPrefix("//example.com/new", "/app"))
+}
+
+func TestMiddleware_ServeHTTP_StripPrefix(t *testing.T) {
+	originalFactory := clientFactory
+	defer func() { clientFactory = originalFactory }()
+
+	var matched []string
+	clientFactory = func(cfg *client.Config) client.Client {
+		return &mockClient{
+			stateVersion: 1,
+			redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+				matched = append(matched, uri)
+				switch uri {
+				case "/old":
+					return &types.Redirect{Source: "/old", Target: "/new", Status: types.RedirectStatusMovedPermanent}, "/new"
+				case "/external":
+					return &types.Redirect{Source: "/external", Target: "https://example.org/", Status: types.RedirectStatusMovedPermanent}, "https://example.org/"
+				}
+				return nil, ""
+			},
+			pageMatch: func(hostname, uri string) *types.Page {
+				if uri == "/robots.txt" {
+					return &types.Page{Path: "/robots.txt", Content: "User-agent: *", ContentType: types.PageContentTypeTextPlain}
+				}
+				return nil
+			},
+		}
+	}
+
+	newMiddleware := func(t *testing.T, restore bool) http.Handler {
+		t.Helper()
+		ctx, cancel := context.WithCancel(context.Background())
+		t.Cleanup(cancel)
+		handler, err := New(ctx, http.NotFoundHandler(), &Config{
+			ClientSettings: ClientSettings{
+				ManagerUrl:    "http://localhost:8080",
+				NamespaceCode: "ns",
+				ProjectCode:   "proj",
+				TokenJWT:      "token",
+				StripPrefix:   "/app",
+				RestorePrefix: restore,
+			},
+		}, "test-strip-prefix")
+		assert.NoError(t, err)
+		return handler
+	}
+	serve := func(handler http.Handler, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+target, nil))
+		return rec
+	}
+
+	t.Run("rules match the uri without the prefix", func(t *testing.T) {
+		matched = nil
+		rec := serve(newMiddleware(t, false), "/app/old")
+		assert.Equal(t, http.StatusMovedPermanently, rec.Code)
+		assert.Equal(t, "/new", rec.Header().Get("Location"))
+		assert.Equal(t, []string{"/old"}, matched)
+	})
+
+	t.Run("restore_prefix re-adds the prefix to relative targets", func(t *testing.T) {
+		handler := newMiddleware(t, true)
+		assert.Equal(t, "/app/new", serve(handler, "/app/old").Header().Get("Location"))
+		assert.Equal(t, "https://example.org/", serve(handler, "/app/external").Header().Get("Location"))
+	})
+
+	t.Run("pages match the uri without the prefix", func(t *testing.T) {
+		rec := serve(newMiddleware(t, false), "/app/robots.txt")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "User-agent: *", rec.Body.String())
+	})
+
+	t.Run("requests outside of the prefix are passed through", func(t *testing.T) {
+		matched = nil
+		handler := newMiddleware(t, false)
+		assert.Equal(t, http.StatusNotFound, serve(handler, "/old").Code)
+		assert.Equal(t, http.StatusNotFound, serve(handler, "/apple/old").Code)
+		assert.Empty(t, matched)
+	})
+}
@@ -0,0 +1,52 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+)
+
+// matchedRules is the outcome of matchRules, passed back by the matching goroutine of serve_timeout.
+type matchedRules struct {
+	redirect *types.Redirect
+	target   string
+	page     *types.Page
+}
+
+// matchRulesWithin runs matchRules for req, giving up after serve_timeout when set.
+// The last result is false when the matching timed out, the matching goroutine then finishes in the background.
+func (m *Middleware) matchRulesWithin(c client.Client, policy *hostPolicy, req *http.Request, uri string) (*types.Redirect, string, *types.Page, bool) {
+	if m.serveTimeout <= 0 {
+		redirect, target, page := m.matchRules(c, policy, req, uri, req.URL)
+		return redirect, target, page, true
+	}
+	// Buffered so the goroutine never blocks once the request has given up on it
+	done := make(chan matchedRules, 1)
+	go func() {
+		redirect, target, page := m.matchRules(c, policy, req, uri, req.URL)
+		done <- matchedRules{redirect: redirect, target: target, page: page}
+	}()
+	timer := time.NewTimer(m.serveTimeout)
+	defer timer.Stop()
+	select {
+	case rules := <-done:
+		return rules.redirect, rules.target, rules.page, true
+	case <-timer.C:
+		return nil, "", nil, false
+	}
+}
+
+// serveMatchTimeout answers a request whose rule matching exceeded serve_timeout with serve_timeout_status,
+// or passes it to the next handler.
+func (m *Middleware) serveMatchTimeout(rw http.ResponseWriter, req *http.Request) (string, int) {
+	m.logf("Rule matching for %s%s exceeded serve_timeout of %s", req.Host, req.URL.RequestURI(), m.serveTimeout)
+	if m.serveTimeoutStatus != 0 {
+		writeSyntheticError(rw, m.syntheticErrorFormat, m.serveTimeoutStatus, http.StatusText(m.serveTimeoutStatus))
+		return decisionTimeout, m.serveTimeoutStatus
+	}
+	// The matching goroutine still reads req, the next handler gets its own copy to modify
+	m.serveNext(rw, req.Clone(req.Context()))
+	return decisionPassthrough, 0
+}
@@ -0,0 +1,74 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_ServeTimeout(t *testing.T) {
+	// The slow client hangs on /slow until released, as a client stuck on a lock would
+	release := make(chan struct{})
+	defer close(release)
+	mock := &mockClient{
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			if uri == "/slow" {
+				<-release
+			}
+			return &types.Redirect{Source: uri, Target: "/new", Status: types.RedirectStatusFound}, "/new"
+		},
+	}
+	newMiddleware := func(serveTimeoutStatus int) (*Middleware, *bool) {
+		nextCalled := false
+		return &Middleware{
+			name: "test",
+			next: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				nextCalled = true
+				w.WriteHeader(http.StatusOK)
+			}),
+			defaultClient:      mock,
+			hostClients:        map[string]client.Client{},
+			serveTimeout:       20 * time.Millisecond,
+			serveTimeoutStatus: serveTimeoutStatus,
+		}, &nextCalled
+	}
+
+	t.Run("fast matching is served", func(t *testing.T) {
+		m, nextCalled := newMiddleware(0)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/old", nil))
+
+		assert.False(t, *nextCalled)
+		assert.Equal(t, http.StatusFound, rec.Code)
+		assert.Equal(t, "/new", rec.Header().Get("Location"))
+	})
+
+	t.Run("timeout passes through", func(t *testing.T) {
+		logs := captureLogs(t)
+		m, nextCalled := newMiddleware(0)
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/slow", nil))
+
+		assert.Less(t, time.Since(start), time.Second)
+		assert.True(t, *nextCalled)
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Empty(t, rec.Header().Get("Location"))
+		assert.Equal(t, "test: Rule matching for example.com/slow exceeded serve_timeout of 20ms\n", logs.String())
+	})
+
+	t.Run("timeout answers the configured status", func(t *testing.T) {
+		captureLogs(t)
+		m, nextCalled := newMiddleware(http.StatusGatewayTimeout)
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/slow", nil))
+
+		assert.False(t, *nextCalled)
+		assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	})
+}
@@ -0,0 +1,19 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+)
+
+// serveURITooLong handles a request whose uri exceeds max_uri_length without matching it:
+// it is answered with a 414 in the strict mode, or passed to the next handler.
+func (m *Middleware) serveURITooLong(rw http.ResponseWriter, req *http.Request, length int) (string, int) {
+	if m.debug {
+		m.logf("Skipping request of %s with a uri of %d bytes, over max_uri_length of %d", req.Host, length, m.maxURILength)
+	}
+	if m.maxURILengthStrict {
+		writeSyntheticError(rw, m.syntheticErrorFormat, http.StatusRequestURITooLong, http.StatusText(http.StatusRequestURITooLong))
+		return decisionURITooLong, http.StatusRequestURITooLong
+	}
+	m.serveNext(rw, req)
+	return decisionPassthrough, 0
+}
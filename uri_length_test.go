@@ -0,0 +1,73 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/flectolab/flecto-manager/common/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_ServeHTTP_MaxUriLength(t *testing.T) {
+	matched := 0
+	mock := &mockClient{
+		stateVersion: 1,
+		redirectMatch: func(hostname, uri string) (*types.Redirect, string) {
+			matched++
+			return &types.Redirect{Source: uri, Target: "/new", Status: types.RedirectStatusFound}, "/new"
+		},
+	}
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	// "/" followed by 15 bytes is exactly 16 bytes long
+	atLimit := "/" + strings.Repeat("a", 15)
+	overLimit := atLimit + "a"
+
+	tests := []struct {
+		name        string
+		strict      bool
+		target      string
+		wantCode    int
+		wantMatched int
+	}{
+		{name: "uri at the limit is matched", target: atLimit, wantCode: http.StatusFound, wantMatched: 1},
+		{name: "uri over the limit is passed through", target: overLimit, wantCode: http.StatusTeapot},
+		{name: "query counts in the length", target: atLimit[:12] + "?q=1", wantCode: http.StatusFound, wantMatched: 1},
+		{name: "query over the limit is passed through", target: atLimit[:12] + "?q=12", wantCode: http.StatusTeapot},
+		{name: "strict uri at the limit is matched", strict: true, target: atLimit, wantCode: http.StatusFound, wantMatched: 1},
+		{name: "strict uri over the limit is rejected", strict: true, target: overLimit, wantCode: http.StatusRequestURITooLong},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched = 0
+			m := &Middleware{next: next, defaultClient: mock, maxURILength: 16, maxURILengthStrict: tt.strict}
+			rec := httptest.NewRecorder()
+			m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+tt.target, nil))
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.Equal(t, tt.wantMatched, matched)
+		})
+	}
+}
+
+func TestMiddleware_ServeHTTP_MaxUriLengthResponseHeaders(t *testing.T) {
+	next := http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(http.StatusTeapot)
+	})
+	overLimit := "/" + strings.Repeat("a", 16)
+
+	for _, strict := range []bool{false, true} {
+		m := &Middleware{
+			next:               next,
+			defaultClient:      &mockClient{},
+			hostPolicies:       map[string]*hostPolicy{"example.com": {responseHeaders: http.Header{"X-Brand": {"flecto"}}}},
+			maxURILength:       16,
+			maxURILengthStrict: strict,
+		}
+		rec := httptest.NewRecorder()
+		m.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com"+overLimit, nil))
+		assert.Equal(t, "flecto", rec.Header().Get("X-Brand"), "strict=%t", strict)
+	}
+}
@@ -0,0 +1,29 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// addVary adds the request headers that influenced a response to its Vary header, once each.
+// Every feature choosing a response from a request header must call it so caches keep the variants apart.
+func addVary(h http.Header, names ...string) {
+	for _, name := range names {
+		if !hasVary(h, name) {
+			h.Add("Vary", name)
+		}
+	}
+}
+
+// hasVary reports whether name is already listed in the Vary header.
+func hasVary(h http.Header, name string) bool {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
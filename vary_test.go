@@ -0,0 +1,70 @@
+package flecto_traefik_middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/flectolab/go-client"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddVary(t *testing.T) {
+	t.Run("adds each header once", func(t *testing.T) {
+		h := http.Header{}
+		addVary(h, "Accept", "X-Forwarded-Proto")
+		addVary(h, "accept")
+		assert.Equal(t, []string{"Accept", "X-Forwarded-Proto"}, h.Values("Vary"))
+	})
+
+	t.Run("keeps headers listed in one value", func(t *testing.T) {
+		h := http.Header{"Vary": {"Accept-Encoding, Cookie"}}
+		addVary(h, "Cookie", "Accept-Language")
+		assert.Equal(t, []string{"Accept-Encoding, Cookie", "Accept-Language"}, h.Values("Vary"))
+	})
+
+	t.Run("wildcard covers every header", func(t *testing.T) {
+		h := http.Header{"Vary": {"*"}}
+		addVary(h, "Accept")
+		assert.Equal(t, []string{"*"}, h.Values("Vary"))
+	})
+}
+
+func TestMiddleware_ServeHTTP_Vary(t *testing.T) {
+	newMiddleware := func(trustForwarded bool) *Middleware {
+		return &Middleware{
+			next:                  http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+			defaultClient:         &mockClient{},
+			hostClients:           map[string]client.Client{},
+			debug:                 true,
+			forceHTTPS:            true,
+			trustForwardedHeaders: trustForwarded,
+		}
+	}
+
+	t.Run("https redirect negotiated on X-Forwarded-Proto", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware(true).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+		assert.Equal(t, []string{"X-Forwarded-Proto"}, rec.Header().Values("Vary"))
+	})
+
+	t.Run("https redirect without forwarded headers", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		newMiddleware(false).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "http://example.com/path", nil))
+
+		assert.Equal(t, http.StatusPermanentRedirect, rec.Code)
+		assert.Empty(t, rec.Header().Values("Vary"))
+	})
+
+	t.Run("test uri", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "https://example.com/path", nil)
+		req.Header.Set(testURIHeader, "/other")
+		rec := httptest.NewRecorder()
+		newMiddleware(false).ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, []string{testURIHeader}, rec.Header().Values("Vary"))
+	})
+}